@@ -3,9 +3,14 @@ package main
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"reflect"
+	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Application struct {
@@ -13,17 +18,49 @@ type Application struct {
 	cancel     context.CancelFunc
 	srv        http.Server
 	inShutdown bool
+
+	configFile string
+	mainClass  string
+	registry   prometheus.Registerer
+
+	mu         sync.Mutex
+	config     *Config
+	discovered map[string]TargetConfig
+	tasks      map[string]*runningTask
+	sets       map[string]*registeredSet
+
+	reloadSuccess   prometheus.Gauge
+	reloadTimestamp prometheus.Gauge
 }
 
-func NewApplication(ctx context.Context) *Application {
+func NewApplication(ctx context.Context, configFile string, mainClass string) *Application {
 
 	ctx, cancel := context.WithCancel(ctx)
 
-	return &Application{
+	a := &Application{
 		ctx:        ctx,
 		cancel:     cancel,
 		inShutdown: false,
+		configFile: configFile,
+		mainClass:  mainClass,
+		registry:   prometheus.DefaultRegisterer,
+		tasks:      make(map[string]*runningTask),
+		sets:       make(map[string]*registeredSet),
 	}
+
+	a.reloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "jcmd_exporter",
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload attempt was successful.",
+	})
+	a.reloadTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "jcmd_exporter",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful configuration reload.",
+	})
+	a.registry.MustRegister(a.reloadSuccess, a.reloadTimestamp)
+
+	return a
 }
 
 func (a *Application) grathefullShutdown(ctx context.Context) error {
@@ -53,5 +90,251 @@ func (a *Application) terminate(s os.Signal) (bool, int) {
 }
 
 func (a *Application) reloadConfig(s os.Signal) (bool, int) {
+
+	if err := a.Reload(); err != nil {
+		log.Printf("ERROR config reload failed - %v\n", err)
+	}
+
 	return false, 0
 }
+
+func (a *Application) readConfig() (*Config, error) {
+
+	if a.configFile == "" {
+		return DefaultConfig(a.mainClass)
+	}
+
+	return LoadConfig(a.configFile)
+}
+
+// Reload re-reads the config and applies the difference: tasks whose
+// definition or metric set changed are restarted, removed ones are stopped
+// and their gauges unregistered. When the config can not be read or one of
+// its metric sets can not be registered, the previous config and all tasks
+// are kept. The first Reload at startup has nothing to keep, main exits.
+func (a *Application) Reload() error {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	err := a.reload()
+
+	if err != nil {
+		a.reloadSuccess.Set(0)
+	} else {
+		a.reloadSuccess.Set(1)
+		a.reloadTimestamp.SetToCurrentTime()
+	}
+
+	return err
+}
+
+func (a *Application) reload() error {
+
+	config, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range config.warnings {
+		log.Printf("WARNING %s\n", warning)
+	}
+
+	old, discovered := a.config, a.discovered
+
+	a.config = config
+	a.discover()
+
+	if err := a.apply(); err != nil {
+		a.config, a.discovered = old, discovered
+		return err
+	}
+
+	return nil
+}
+
+// targets returns the configured targets followed by the discovered ones.
+// A discovered target never replaces a configured one with the same name.
+func (a *Application) targets() []TargetConfig {
+
+	targets := make([]TargetConfig, 0, len(a.config.Targets)+len(a.discovered))
+	names := make(map[string]bool)
+
+	for _, t := range a.config.Targets {
+		targets = append(targets, t)
+		names[t.Name] = true
+	}
+
+	for name, t := range a.discovered {
+		if !names[name] {
+			targets = append(targets, t)
+		}
+	}
+
+	return targets
+}
+
+// apply starts and stops tasks so that they match a.config and the
+// discovered targets. The new and changed metric sets are registered before
+// any task is stopped, if one of them fails nothing is changed. Must be
+// called with a.mu held.
+func (a *Application) apply() error {
+
+	config := a.config
+	all := a.targets()
+
+	used := make(map[string]bool)
+	for _, t := range all {
+		used[t.Metrics] = true
+	}
+
+	labelNames := make(map[string][]string)
+	for name := range used {
+		labelNames[name] = SetLabelNames(all, name)
+	}
+
+	changedSets := make(map[string]bool)
+	for name, set := range a.sets {
+		if !used[name] ||
+			!reflect.DeepEqual(set.attrs, config.MetricSets[name]) ||
+			!reflect.DeepEqual(set.labelNames, labelNames[name]) ||
+			!reflect.DeepEqual(set.converters, setConverters(set.attrs, config.Converters)) {
+			changedSets[name] = true
+		}
+	}
+
+	// the changed sets keep their metric names, so they are registered
+	// again after the old ones are unregistered
+	for name := range changedSets {
+		a.sets[name].vecs.Unregister(a.registry)
+	}
+
+	registered := make(map[string]*registeredSet)
+
+	for name := range used {
+		if _, ok := a.sets[name]; ok && !changedSets[name] {
+			continue
+		}
+
+		attrs := config.MetricSets[name]
+		vecs, err := NewMetricVecs(a.registry, name, &attrs, labelNames[name], config.converters)
+		if err != nil {
+			for _, set := range registered {
+				set.vecs.Unregister(a.registry)
+			}
+			for changed := range changedSets {
+				if rerr := a.sets[changed].vecs.Register(a.registry); rerr != nil {
+					log.Printf("ERROR can not register metric set '%s' again - %v\n", changed, rerr)
+				}
+			}
+			return fmt.Errorf("metric set '%s': %v", name, err)
+		}
+
+		registered[name] = &registeredSet{
+			attrs:      attrs,
+			labelNames: labelNames[name],
+			converters: setConverters(attrs, config.Converters),
+			vecs:       vecs,
+		}
+	}
+
+	targets := make(map[string]*TargetConfig, len(all))
+	for i := range all {
+		targets[all[i].Name] = &all[i]
+	}
+
+	for name, rt := range a.tasks {
+		t, ok := targets[name]
+		if ok && !changedSets[rt.config.Metrics] && reflect.DeepEqual(rt.config, *t) &&
+			parserDefsEqual(rt.parsers, config.Parsers[t.SubSystem]) {
+			continue
+		}
+
+		log.Printf("INFO stopping task %s\n", name)
+		a.stopTask(rt)
+		delete(a.tasks, name)
+	}
+
+	for name := range changedSets {
+		delete(a.sets, name)
+	}
+	for name, set := range registered {
+		a.sets[name] = set
+	}
+
+	for _, t := range all {
+		if _, ok := a.tasks[t.Name]; ok {
+			continue
+		}
+
+		log.Printf("INFO starting task %s\n", t.Name)
+		a.tasks[t.Name] = a.startTask(t, a.sets[t.Metrics])
+	}
+
+	return nil
+}
+
+func (a *Application) startTask(t TargetConfig, set *registeredSet) *runningTask {
+
+	task := NewJcmdTask(&t, set, a.config)
+
+	if task.Mode == MODE_SCRAPE {
+		// collected by scrapeCollector on every /metrics request
+		return &runningTask{config: t, parsers: a.config.Parsers[t.SubSystem], task: task}
+	}
+
+	ctx, cancel := context.WithCancel(a.ctx)
+
+	return &runningTask{
+		config:  t,
+		parsers: a.config.Parsers[t.SubSystem],
+		task:    task,
+		cancel:  cancel,
+		done:    RunTask(ctx, task),
+	}
+}
+
+func (a *Application) stopTask(rt *runningTask) {
+
+	if rt.cancel != nil {
+		rt.cancel()
+		<-rt.done
+	}
+
+	rt.task.Stop()
+	skippedTicks.DeleteLabelValues(rt.task.Name)
+	nmtBaselineTimestamp.DeleteLabelValues(rt.task.Name)
+}
+
+// scrapeTasks returns the tasks that are collected synchronously with scrapes.
+func (a *Application) scrapeTasks() []*JcmdTask {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tasks := make([]*JcmdTask, 0)
+	for _, rt := range a.tasks {
+		if rt.task.Mode == MODE_SCRAPE {
+			tasks = append(tasks, rt.task)
+		}
+	}
+
+	return tasks
+}
+
+func (a *Application) reloadHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "This endpoint requires a POST request.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := a.Reload(); err != nil {
+		log.Printf("ERROR config reload failed - %v\n", err)
+		http.Error(w, fmt.Sprintf("failed to reload config: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintln(w, "OK")
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestApplication returns an application reading the config file at
+// path with a registry of its own.
+func newTestApplication(t *testing.T, path string) *Application {
+
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	a := &Application{
+		ctx:        ctx,
+		cancel:     cancel,
+		configFile: path,
+		registry:   prometheus.NewRegistry(),
+		tasks:      make(map[string]*runningTask),
+		sets:       make(map[string]*registeredSet),
+		reloadSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "jcmd_exporter",
+			Name:      "config_last_reload_successful",
+			Help:      "Whether the last configuration reload attempt was successful.",
+		}),
+		reloadTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "reload_timestamp",
+			Help: "Timestamp of the last successful configuration reload.",
+		}),
+	}
+	a.registry.MustRegister(a.reloadSuccess, a.reloadTimestamp)
+
+	t.Cleanup(func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		for _, rt := range a.tasks {
+			a.stopTask(rt)
+		}
+		cancel()
+	})
+
+	return a
+}
+
+func setConfig(t *testing.T, path string, content string) {
+
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reloadTasks reloads the config and returns the running tasks by name.
+func reloadTasks(t *testing.T, a *Application) map[string]*runningTask {
+
+	t.Helper()
+
+	if err := a.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	return runningTasks(a)
+}
+
+func runningTasks(a *Application) map[string]*runningTask {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tasks := make(map[string]*runningTask, len(a.tasks))
+	for name, rt := range a.tasks {
+		tasks[name] = rt
+	}
+
+	return tasks
+}
+
+func isStopped(task *JcmdTask) bool {
+
+	task.collectMu.Lock()
+	defer task.collectMu.Unlock()
+
+	return task.stopped
+}
+
+// scrape mode targets have no goroutine, so nothing runs in the tests
+const reloadConfig = `
+defaults:
+  mode: scrape
+targets:
+  - name: a
+    pid: 999991
+  - name: b
+    pid: 999992
+`
+
+func TestReload(t *testing.T) {
+
+	path := writeConfig(t, reloadConfig)
+	a := newTestApplication(t, path)
+
+	first := reloadTasks(t, a)
+	if len(first) != 2 || first["a"] == nil || first["b"] == nil {
+		t.Fatalf("tasks %v, want a and b", first)
+	}
+
+	// b changed, c is new
+	setConfig(t, path, `
+defaults:
+  mode: scrape
+targets:
+  - name: a
+    pid: 999991
+  - name: b
+    pid: 999992
+    timer_ms: 5000
+  - name: c
+    pid: 999993
+`)
+
+	second := reloadTasks(t, a)
+	if len(second) != 3 {
+		t.Fatalf("tasks %v, want a, b and c", second)
+	}
+	if second["a"] != first["a"] || isStopped(first["a"].task) {
+		t.Error("unchanged task a was restarted")
+	}
+	if second["b"] == first["b"] || !isStopped(first["b"].task) || second["b"].task.TimerMs != 5000 {
+		t.Error("changed task b was not restarted")
+	}
+
+	// b and c are removed
+	setConfig(t, path, `
+defaults:
+  mode: scrape
+targets:
+  - name: a
+    pid: 999991
+`)
+
+	third := reloadTasks(t, a)
+	if len(third) != 1 || third["a"] != first["a"] {
+		t.Fatalf("tasks %v, want a only", third)
+	}
+	if !isStopped(second["b"].task) || !isStopped(second["c"].task) {
+		t.Error("removed tasks b and c were not stopped")
+	}
+
+	if got := testutil.ToFloat64(a.reloadSuccess); got != 1 {
+		t.Errorf("reload successful %v, want 1", got)
+	}
+}
+
+func TestReloadRollback(t *testing.T) {
+
+	path := writeConfig(t, reloadConfig)
+	a := newTestApplication(t, path)
+
+	first := reloadTasks(t, a)
+	config := a.config
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"invalid config", "targets: []"},
+		// the metric of the set collides with the reload gauge, the set of
+		// the restarted tasks can not be registered
+		{"metric set registration", `
+defaults:
+  mode: scrape
+  metrics: exporter
+metric_sets:
+  exporter:
+    - regex_group: to_resv_kb
+      name: config_last_reload_successful
+      help: collides
+targets:
+  - name: a
+    pid: 999991
+  - name: b
+    pid: 999992
+`},
+	}
+
+	for _, tt := range tests {
+
+		setConfig(t, path, tt.config)
+
+		if err := a.Reload(); err == nil {
+			t.Fatalf("%s: reload did not fail", tt.name)
+		}
+
+		if got := testutil.ToFloat64(a.reloadSuccess); got != 0 {
+			t.Errorf("%s: reload successful %v, want 0", tt.name, got)
+		}
+
+		if a.config != config {
+			t.Errorf("%s: config was replaced", tt.name)
+		}
+
+		tasks := runningTasks(a)
+		for name, rt := range first {
+			if tasks[name] != rt || isStopped(rt.task) {
+				t.Errorf("%s: task %s was stopped", tt.name, name)
+			}
+		}
+
+		// the native memory set is still registered
+		if err := a.sets[DEFAULT_METRICS_SET].vecs.Register(a.registry); err == nil {
+			t.Errorf("%s: metric set %s was unregistered", tt.name, DEFAULT_METRICS_SET)
+		}
+	}
+
+	// a good config is applied after a failed one
+	setConfig(t, path, `
+defaults:
+  mode: scrape
+targets:
+  - name: a
+    pid: 999991
+    timer_ms: 5000
+`)
+
+	tasks := reloadTasks(t, a)
+	if len(tasks) != 1 || tasks["a"] == first["a"] || !isStopped(first["b"].task) {
+		t.Errorf("tasks %v after a good reload", tasks)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// HotSpot dynamic attach on Linux, see LinuxVirtualMachine.java and
+// src/hotspot/os/linux/attachListener_linux.cpp
+const (
+	ATTACH_PROTOCOL_VERSION = "1"
+	ATTACH_TMP_DIR          = "/tmp"
+	ATTACH_POLL_INTERVAL    = 100 * time.Millisecond
+)
+
+// attachExecutor talks the attach protocol to the JVM directly instead of
+// starting a jcmd JVM for every call.
+type attachExecutor struct {
+	hsperfdataDir string
+}
+
+func (e *attachExecutor) Execute(ctx context.Context, timeout time.Duration, target string, command []string) (string, error) {
+
+	pid, err := e.pid(target)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := Attach(ctx, pid)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	return AttachCommand(ctx, conn, "jcmd", strings.Join(command, " "))
+}
+
+func (e *attachExecutor) ExecuteStream(ctx context.Context, timeout time.Duration, target string, command []string, parse func(io.Reader) error) error {
+
+	pid, err := e.pid(target)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := Attach(ctx, pid)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r, err := attachRequest(ctx, conn, "jcmd", strings.Join(command, " "))
+	if err != nil {
+		return err
+	}
+
+	return parse(r)
+}
+
+// pid returns the pid of target, a pid or a main class.
+func (e *attachExecutor) pid(target string) (int, error) {
+
+	if pid, err := strconv.Atoi(target); err == nil {
+		return pid, nil
+	}
+
+	jvm := FindJvm(e.hsperfdataDir, 0, target)
+	if jvm == nil {
+		return 0, fmt.Errorf("can not find a single JVM with main class %s", target)
+	}
+
+	return jvm.Pid, nil
+}
+
+// Attach connects to the attach listener of the JVM, starting the listener
+// first if needed: the JVM starts it on SIGQUIT when an .attach_pid<pid>
+// file exists in its working directory or in /tmp. A JVM in another
+// namespace is reached through /proc/<pid>/root with its namespace pid.
+func Attach(ctx context.Context, pid int) (net.Conn, error) {
+
+	ns, err := GetProcessNs(pid)
+	if err != nil {
+		return nil, fmt.Errorf("can not inspect process %d - %v", pid, err)
+	}
+
+	socket := ns.Path(filepath.Join(ATTACH_TMP_DIR, fmt.Sprintf(".java_pid%d", ns.NsPid)))
+
+	if !isSocket(socket) {
+		if err := startAttachListener(ctx, ns, socket); err != nil {
+			return nil, err
+		}
+	}
+
+	var conn net.Conn
+
+	if ns.RunAs() {
+		conn, err = dialAs(ctx, socket, ns.Uid, ns.Gid)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "unix", socket)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("can not connect to %s - %v", socket, err)
+	}
+
+	return conn, nil
+}
+
+func startAttachListener(ctx context.Context, ns *ProcessNs, socket string) error {
+
+	attachFile, err := createAttachFile(ns)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(attachFile)
+
+	if err := syscall.Kill(ns.Pid, syscall.SIGQUIT); err != nil {
+		return fmt.Errorf("can not signal process %d - %v", ns.Pid, err)
+	}
+
+	ticker := time.NewTicker(ATTACH_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for !isSocket(socket) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("attach listener of process %d not started - %v", ns.Pid, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// createAttachFile creates the trigger file, owned by the JVM user when we
+// run as root, since HotSpot ignores files of other users.
+func createAttachFile(ns *ProcessNs) (string, error) {
+
+	name := fmt.Sprintf(".attach_pid%d", ns.NsPid)
+
+	candidates := []string{
+		filepath.Join("/proc", strconv.Itoa(ns.Pid), "cwd", name),
+		ns.Path(filepath.Join(ATTACH_TMP_DIR, name)),
+	}
+
+	var err error
+
+	for _, path := range candidates {
+		var f *os.File
+		if f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0660); err != nil {
+			continue
+		}
+		f.Close()
+
+		if ns.RunAs() {
+			if err = os.Chown(path, ns.Uid, ns.Gid); err != nil {
+				os.Remove(path)
+				continue
+			}
+		}
+
+		return path, nil
+	}
+
+	return "", fmt.Errorf("can not create attach file for process %d - %v", ns.Pid, err)
+}
+
+func isSocket(path string) bool {
+
+	fi, err := os.Stat(path)
+
+	return err == nil && fi.Mode()&os.ModeSocket != 0
+}
+
+// AttachCommand sends one request and returns the response body.
+func AttachCommand(ctx context.Context, conn net.Conn, cmd string, args ...string) (string, error) {
+
+	r, err := attachRequest(ctx, conn, cmd, args...)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("can not read attach response - %v", err)
+	}
+
+	return string(body), nil
+}
+
+// attachRequest sends one request and returns the response body to read
+// once the request succeeded. A request is the protocol version, the
+// command and exactly three arguments, all NUL terminated. The response
+// starts with the result code on its own line.
+func attachRequest(ctx context.Context, conn net.Conn, cmd string, args ...string) (io.Reader, error) {
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	request := make([]string, 3)
+	copy(request, args)
+
+	var b strings.Builder
+	b.WriteString(ATTACH_PROTOCOL_VERSION + "\x00")
+	b.WriteString(cmd + "\x00")
+	for _, arg := range request {
+		b.WriteString(arg + "\x00")
+	}
+
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		return nil, fmt.Errorf("can not send attach request - %v", err)
+	}
+
+	r := bufio.NewReader(conn)
+
+	status, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("can not read attach response - %v", err)
+	}
+
+	code, err := strconv.Atoi(strings.TrimSpace(status))
+	if err != nil {
+		return nil, fmt.Errorf("bad attach response status '%s'", strings.TrimSpace(status))
+	}
+
+	if code != 0 {
+		body, _ := ioutil.ReadAll(r)
+		return nil, fmt.Errorf("attach command %s failed with code %d - %s", cmd, code, strings.TrimSpace(string(body)))
+	}
+
+	return r, nil
+}
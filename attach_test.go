@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeAttachListener answers one attach request on conn with response and
+// sends the request it read, NULs shown as "|", to requests.
+func fakeAttachListener(conn net.Conn, response string, requests chan<- string) {
+
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	request := ""
+
+	// version, command and three arguments
+	for i := 0; i < 5; i++ {
+		s, err := r.ReadString(0)
+		if err != nil {
+			requests <- request + "error: " + err.Error()
+			return
+		}
+		request += strings.TrimSuffix(s, "\x00") + "|"
+	}
+
+	requests <- request
+	conn.Write([]byte(response))
+}
+
+func TestAttachCommand(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		args     []string
+		response string
+		want     string
+		wantReq  string
+		wantErr  string
+	}{
+		{"ok", []string{"VM.version"}, "0\nOpenJDK 64-Bit Server VM version 17.0.9\n", "OpenJDK 64-Bit Server VM version 17.0.9\n", "1|jcmd|VM.version|||", ""},
+		{"arguments", []string{"VM.native_memory summary", "x", "y"}, "0\n", "", "1|jcmd|VM.native_memory summary|x|y|", ""},
+		{"failed", []string{"VM.nope"}, "1\njava.lang.IllegalArgumentException: Unknown diagnostic command\n", "", "1|jcmd|VM.nope|||", "failed with code 1 - java.lang.IllegalArgumentException: Unknown diagnostic command"},
+		{"bad status", []string{"VM.version"}, "ok\n", "", "1|jcmd|VM.version|||", "bad attach response status 'ok'"},
+		{"no status", []string{"VM.version"}, "", "", "1|jcmd|VM.version|||", "can not read attach response"},
+	}
+
+	for _, tt := range tests {
+
+		client, server := net.Pipe()
+		requests := make(chan string, 1)
+		go fakeAttachListener(server, tt.response, requests)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		got, err := AttachCommand(ctx, client, "jcmd", tt.args...)
+		cancel()
+		client.Close()
+
+		if req := <-requests; req != tt.wantReq {
+			t.Errorf("%s: request %q, want %q", tt.name, req, tt.wantReq)
+		}
+
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestAttachCommandTimeout(t *testing.T) {
+
+	client, server := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	// the JVM never answers
+	go bufio.NewReader(server).ReadString('\n')
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := AttachCommand(ctx, client, "jcmd", "VM.version"); err == nil {
+		t.Error("no error without a response")
+	}
+}
+
+// TestAttachExecutor talks to a fake attach listener at the socket of the
+// test process, as a JVM with a running listener would provide.
+func TestAttachExecutor(t *testing.T) {
+
+	socket := filepath.Join(ATTACH_TMP_DIR, fmt.Sprintf(".java_pid%d", os.Getpid()))
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("can not listen on %s - %v", socket, err)
+	}
+	defer l.Close()
+
+	requests := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			requests <- err.Error()
+			return
+		}
+		fakeAttachListener(conn, "0\n12345:\nOpenJDK 64-Bit Server VM version 17.0.9\n", requests)
+	}()
+
+	e := &attachExecutor{}
+	output, err := e.Execute(context.Background(), 5*time.Second, strconv.Itoa(os.Getpid()), []string{"VM.native_memory", "summary"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req := <-requests; req != "1|jcmd|VM.native_memory summary|||" {
+		t.Errorf("request %q", req)
+	}
+
+	if output != "12345:\nOpenJDK 64-Bit Server VM version 17.0.9\n" {
+		t.Errorf("output %q", output)
+	}
+}
+
+func TestAttachExecutorPid(t *testing.T) {
+
+	dir := t.TempDir()
+	writeHsperfdata(t, dir, "app", os.Getpid(), "com.example.App")
+
+	e := &attachExecutor{hsperfdataDir: dir}
+
+	tests := []struct {
+		target  string
+		want    int
+		wantErr bool
+	}{
+		{"12345", 12345, false},
+		{"App", os.Getpid(), false},
+		{"Missing", 0, true},
+	}
+
+	for _, tt := range tests {
+		pid, err := e.pid(tt.target)
+		if (err != nil) != tt.wantErr || pid != tt.want {
+			t.Errorf("%s: pid %d, error %v, want %d", tt.target, pid, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	CLASS_HISTOGRAM_COMMAND     = "GC.class_histogram"
+	CLASS_HISTOGRAM_METRICS_SET = "class_histogram"
+
+	// counts all objects instead of the live ones, see all_objects
+	CLASS_HISTOGRAM_ALL = "-all"
+
+	DEFAULT_PACKAGE_DEPTH = 2
+
+	// without -all every collection runs a full GC
+	DEFAULT_CLASS_HISTOGRAM_MIN_INTERVAL_MS = 60000
+
+	// class and package label of the classes beyond the top N
+	CLASS_OTHER = "other"
+
+	// package label of primitive arrays and classes without a package
+	PACKAGE_PRIMITIVE = "[primitive]"
+	PACKAGE_DEFAULT   = "(default)"
+)
+
+var (
+	classRowRE   = regexp.MustCompile(`^\s*\d+:\s+(\d+)\s+(\d+)\s+(\S+)`)
+	classTotalRE = regexp.MustCompile(`^Total\s+(\d+)\s+(\d+)`)
+)
+
+func init() {
+	RegisterParser(CLASS_HISTOGRAM_COMMAND, newClassHistogramParser)
+}
+
+// classHistogramParser keeps the TopN classes of GC.class_histogram by
+// bytes and rolls all classes up to packages of PackageDepth elements, both
+// with the rest summed up as "other".
+type classHistogramParser struct {
+	topN  int
+	depth int
+}
+
+func newClassHistogramParser(task *JcmdTask) Parser {
+	return &classHistogramParser{topN: task.TopN, depth: task.PackageDepth}
+}
+
+func (p *classHistogramParser) Groups() []string {
+
+	return []string{"instances", "bytes", "package_instances", "package_bytes", "total_instances", "total_bytes"}
+}
+
+func (p *classHistogramParser) Labels(group string) []string {
+
+	switch group {
+	case "instances", "bytes":
+		return []string{"class"}
+	case "package_instances", "package_bytes":
+		return []string{"package"}
+	}
+
+	return nil
+}
+
+// classCount is the instances and bytes of a class or package.
+type classCount struct {
+	name      string
+	instances int64
+	bytes     int64
+}
+
+func (p *classHistogramParser) Parse(output string) ([]Sample, error) {
+
+	classes := make([]classCount, 0)
+	packages := make(map[string]*classCount)
+	var total *classCount
+
+	for _, line := range strings.Split(output, "\n") {
+
+		if m := classRowRE.FindStringSubmatch(line); m != nil {
+			c := classCount{name: m[3], instances: atoi64(m[1]), bytes: atoi64(m[2])}
+			classes = append(classes, c)
+
+			name := classPackage(c.name, p.depth)
+			pkg, ok := packages[name]
+			if !ok {
+				pkg = &classCount{name: name}
+				packages[name] = pkg
+			}
+			pkg.instances += c.instances
+			pkg.bytes += c.bytes
+			continue
+		}
+
+		if m := classTotalRE.FindStringSubmatch(line); m != nil {
+			total = &classCount{instances: atoi64(m[1]), bytes: atoi64(m[2])}
+		}
+	}
+
+	if total == nil {
+		return nil, fmt.Errorf("no class histogram in output")
+	}
+
+	pkgs := make([]classCount, 0, len(packages))
+	for _, pkg := range packages {
+		pkgs = append(pkgs, *pkg)
+	}
+
+	samples := make([]Sample, 0, 4*p.topN+6)
+
+	for _, c := range classTop(classes, p.topN) {
+		labels := map[string]string{"class": c.name}
+		samples = append(samples,
+			Sample{Name: "instances", Value: strconv.FormatInt(c.instances, 10), Labels: labels},
+			Sample{Name: "bytes", Value: strconv.FormatInt(c.bytes, 10), Labels: labels},
+		)
+	}
+
+	for _, c := range classTop(pkgs, p.topN) {
+		labels := map[string]string{"package": c.name}
+		samples = append(samples,
+			Sample{Name: "package_instances", Value: strconv.FormatInt(c.instances, 10), Labels: labels},
+			Sample{Name: "package_bytes", Value: strconv.FormatInt(c.bytes, 10), Labels: labels},
+		)
+	}
+
+	samples = append(samples,
+		Sample{Name: "total_instances", Value: strconv.FormatInt(total.instances, 10)},
+		Sample{Name: "total_bytes", Value: strconv.FormatInt(total.bytes, 10)},
+	)
+
+	return samples, nil
+}
+
+// classTop returns the topN biggest counts by bytes and, if there are more,
+// the sum of the others as "other".
+func classTop(counts []classCount, topN int) []classCount {
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].bytes != counts[j].bytes {
+			return counts[i].bytes > counts[j].bytes
+		}
+		return counts[i].name < counts[j].name
+	})
+
+	if len(counts) <= topN {
+		return counts
+	}
+
+	other := classCount{name: CLASS_OTHER}
+	for _, c := range counts[topN:] {
+		other.instances += c.instances
+		other.bytes += c.bytes
+	}
+
+	return append(counts[:topN:topN], other)
+}
+
+// classPackage returns the first depth elements of the package of a class,
+// the package of the element class for arrays of objects.
+func classPackage(class string, depth int) string {
+
+	class = strings.TrimLeft(class, "[")
+
+	if strings.HasPrefix(class, "L") && strings.HasSuffix(class, ";") {
+		class = class[1 : len(class)-1]
+	} else if len(class) == 1 {
+		return PACKAGE_PRIMITIVE
+	}
+
+	parts := strings.Split(class, ".")
+	if len(parts) == 1 {
+		return PACKAGE_DEFAULT
+	}
+
+	parts = parts[:len(parts)-1]
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestClassHistogramParser(t *testing.T) {
+
+	tests := []struct {
+		file  string
+		cases []sampleCase
+	}{
+		{"class_histogram/jdk8.txt", []sampleCase{
+			{"instances", "class", "[C", "4310"},
+			{"bytes", "class", "[C", "396112"},
+			{"bytes", "class", "[B", "137792"},
+			{"instances", "class", "java.lang.String", "4182"},
+			{"instances", "class", "other", "2347"},
+			{"bytes", "class", "other", "155032"},
+			{"package_bytes", "package", "[primitive]", "533904"},
+			{"package_instances", "package", "java.lang", "5619"},
+			{"package_bytes", "package", "other", "16"},
+			{"total_instances", "", "", "11318"},
+			{"total_bytes", "", "", "789304"},
+		}},
+		{"class_histogram/jdk17.txt", []sampleCase{
+			{"instances", "class", "[B", "18212"},
+			{"bytes", "class", "[B", "1630288"},
+			{"bytes", "class", "java.lang.Class", "453496"},
+			{"instances", "class", "java.lang.String", "17402"},
+			{"bytes", "class", "other", "1769520"},
+			{"package_instances", "package", "java.lang", "28878"},
+			{"package_bytes", "package", "java.util", "829648"},
+			{"package_instances", "package", "other", "7209"},
+			{"total_instances", "", "", "74619"},
+			{"total_bytes", "", "", "4271952"},
+		}},
+	}
+
+	for _, tt := range tests {
+
+		p := newClassHistogramParser(&JcmdTask{TopN: 3, PackageDepth: 2})
+
+		samples, err := p.Parse(readExample(t, tt.file))
+		if err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+			continue
+		}
+
+		t.Run(tt.file, func(t *testing.T) {
+			checkSamples(t, samples, tt.cases)
+		})
+	}
+}
+
+func TestClassHistogramAllObjects(t *testing.T) {
+
+	c, err := LoadConfig(writeConfig(t, `
+targets:
+  - name: live
+    pid: 42
+    subsystem: GC.class_histogram
+  - name: all
+    pid: 42
+    subsystem: GC.class_histogram
+    all_objects: true
+    extra_args: ["-all"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target      TargetConfig
+		command     []string
+		minInterval int
+	}{
+		// a live histogram runs a full GC
+		{c.Targets[0], []string{CLASS_HISTOGRAM_COMMAND}, DEFAULT_CLASS_HISTOGRAM_MIN_INTERVAL_MS},
+		{c.Targets[1], []string{CLASS_HISTOGRAM_COMMAND, CLASS_HISTOGRAM_ALL}, 0},
+	}
+
+	for _, tt := range tests {
+
+		if tt.target.MinIntervalMs != tt.minInterval {
+			t.Errorf("%s: min_interval_ms %d, want %d", tt.target.Name, tt.target.MinIntervalMs, tt.minInterval)
+		}
+
+		task := &JcmdTask{SubSystem: tt.target.SubSystem, ExtraArgs: tt.target.ExtraArgs, AllObjects: *tt.target.AllObjects}
+		if got := task.Command(); !reflect.DeepEqual(got, tt.command) {
+			t.Errorf("%s: command %v, want %v", tt.target.Name, got, tt.command)
+		}
+	}
+
+	if _, err := LoadConfig(writeConfig(t, `
+targets:
+  - pid: 42
+    all_objects: true
+`)); err == nil {
+		t.Error("no error for all_objects without GC.class_histogram")
+	}
+}
+
+func TestCollectMinInterval(t *testing.T) {
+
+	labelNames := []string{LABEL_PID, LABEL_MAIN_CLASS, LABEL_USER}
+	vecs := newTestVecs(t, labelNames)
+
+	labels := func(pid int) prometheus.Labels {
+		return prometheus.Labels{"pid": strconv.Itoa(pid), "main_class": "", "user": ""}
+	}
+
+	executor := &fakeExecutor{output: "1"}
+	task := &JcmdTask{
+		Name:          "a",
+		Executor:      executor,
+		Parser:        valueParser,
+		Pid:           os.Getpid(),
+		MinIntervalMs: 60000,
+		labels:        labels(os.Getpid()),
+		Metrics:       vecs.NewMetricsMap(labels(os.Getpid())),
+	}
+
+	for i := 0; i < 2; i++ {
+		task.Collect(context.Background(), time.Second)
+	}
+	if executor.calls != 1 {
+		t.Errorf("%d calls within min_interval_ms, want 1", executor.calls)
+	}
+
+	// a restarted JVM gets its first run right away
+	task.setLabels(labels(os.Getppid()), nil)
+	task.Pid = os.Getppid()
+	task.Collect(context.Background(), time.Second)
+	if executor.calls != 2 {
+		t.Errorf("%d calls after the pid changed, want 2", executor.calls)
+	}
+
+	// other labels of the same JVM do not
+	task.setLabels(prometheus.Labels{"pid": strconv.Itoa(os.Getppid()), "main_class": "App", "user": ""}, nil)
+	task.Collect(context.Background(), time.Second)
+	if executor.calls != 2 {
+		t.Errorf("%d calls after the labels changed, want 2", executor.calls)
+	}
+}
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	DEFAULT_PATH_JCMD   = "jcmd"
+	DEFAULT_SUBSYSTEM   = "VM.native_memory"
+	DEFAULT_METRICS_SET = "native_memory"
+	DEFAULT_TIMER_MS    = 10000
+	DEFAULT_TIMEOUT_MS  = 5000
+	DEFAULT_STALE_AFTER = 3
+
+	LABEL_PID        = "pid"
+	LABEL_MAIN_CLASS = "main_class"
+	LABEL_USER       = "user"
+
+	EXECUTOR_EXEC   = "exec"
+	EXECUTOR_ATTACH = "attach"
+	EXECUTOR_NONE   = "none"
+
+	MODE_BACKGROUND = "background"
+	MODE_SCRAPE     = "scrape"
+)
+
+// built-in metric set of the targets of a jcmd command without metrics,
+// DEFAULT_METRICS_SET for other commands
+var commandMetricSets = map[string]string{
+	NMT_COMMAND:             DEFAULT_METRICS_SET,
+	HEAP_INFO_COMMAND:       HEAP_INFO_METRICS_SET,
+	THREAD_PRINT_COMMAND:    THREAD_PRINT_METRICS_SET,
+	CLASS_HISTOGRAM_COMMAND: CLASS_HISTOGRAM_METRICS_SET,
+	VM_FLAGS_COMMAND:        VM_FLAGS_METRICS_SET,
+	VM_COMMAND_LINE_COMMAND: VM_FLAGS_METRICS_SET,
+}
+
+// LoadConfig reads a YAML (or JSON, which is valid YAML) config file and
+// returns it with defaults applied and all targets validated.
+func LoadConfig(path string) (*Config, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read config file %s - %v", path, err)
+	}
+
+	var c Config
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, fmt.Errorf("can not parse config file %s - %v", path, err)
+	}
+
+	if err := c.loadDefinitions(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("invalid config file %s - %v", path, err)
+	}
+
+	if err := c.prepare(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s - %v", path, err)
+	}
+
+	return &c, nil
+}
+
+// DefaultConfig is used when no config file is given: a single target
+// identified by its main class with the built-in native memory metrics.
+func DefaultConfig(mainClass string) (*Config, error) {
+
+	c := Config{
+		Targets: []TargetConfig{
+			{MainClass: mainClass},
+		},
+	}
+
+	if err := c.prepare(); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// loadDefinitions adds the parsers, metric sets and converters of the
+// definitions files, relative paths are taken from dir. Each of them may be
+// defined only once.
+func (c *Config) loadDefinitions(dir string) error {
+
+	if c.Parsers == nil {
+		c.Parsers = make(map[string][]ParserDef)
+	}
+
+	if c.MetricSets == nil {
+		c.MetricSets = make(map[string][]MetricDescAttr)
+	}
+
+	if c.Converters == nil {
+		c.Converters = make(map[string]ConverterDef)
+	}
+
+	for _, path := range c.Definitions {
+
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("can not read definitions file %s - %v", path, err)
+		}
+
+		var d Definitions
+		if err := yaml.UnmarshalStrict(data, &d); err != nil {
+			return fmt.Errorf("can not parse definitions file %s - %v", path, err)
+		}
+
+		for command, defs := range d.Parsers {
+			if _, ok := c.Parsers[command]; ok {
+				return fmt.Errorf("%s: parser for '%s' is already defined", path, command)
+			}
+			c.Parsers[command] = defs
+		}
+
+		for name, set := range d.MetricSets {
+			if _, ok := c.MetricSets[name]; ok {
+				return fmt.Errorf("%s: metric set '%s' is already defined", path, name)
+			}
+			c.MetricSets[name] = set
+		}
+
+		for name, def := range d.Converters {
+			if _, ok := c.Converters[name]; ok {
+				return fmt.Errorf("%s: converter '%s' is already defined", path, name)
+			}
+			c.Converters[name] = def
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) prepare() error {
+
+	if c.Parsers == nil {
+		c.Parsers = make(map[string][]ParserDef)
+	}
+
+	if c.MetricSets == nil {
+		c.MetricSets = make(map[string][]MetricDescAttr)
+	}
+
+	builtin, err := ParseMetricSets([]byte(DEFAULT_METRICS_JSON))
+	if err != nil {
+		return fmt.Errorf("built-in metric sets: %v", err)
+	}
+
+	// built-in sets are replaced by sets of the same name in the config
+	for name, set := range builtin {
+		if _, ok := c.MetricSets[name]; ok {
+			continue
+		}
+		if name == DEFAULT_METRICS_SET {
+			set = withNmtDeltas(set)
+		}
+		c.MetricSets[name] = set
+	}
+
+	c.converters = make(map[string]ConvertFunction, len(c.Converters))
+
+	for name, def := range c.Converters {
+		if _, ok := converters[name]; ok {
+			return fmt.Errorf("converter '%s' is built in", name)
+		}
+
+		fn, err := def.compile()
+		if err != nil {
+			return fmt.Errorf("converter '%s': %v", name, err)
+		}
+		c.converters[name] = fn
+	}
+
+	for name, set := range c.MetricSets {
+		if len(set) == 0 {
+			return fmt.Errorf("metric set '%s' is empty", name)
+		}
+
+		for i, attr := range set {
+			if attr.ReGroup == "" || attr.Name == "" {
+				return fmt.Errorf("metric set '%s' item %d: regex_group and name are required", name, i)
+			}
+
+			if _, err := LookupConverter(attr.Convert, c.converters); err != nil {
+				return fmt.Errorf("metric set '%s' item %d: %v", name, i, err)
+			}
+
+			if _, ok := metricValueTypes[attr.Type]; !ok {
+				return fmt.Errorf("metric set '%s' item %d: type must be '%s', '%s' or '%s', got '%s'", name, i, METRIC_TYPE_GAUGE, METRIC_TYPE_COUNTER, METRIC_TYPE_UNTYPED, attr.Type)
+			}
+
+			for _, label := range attr.Labels {
+				if !labelNameRE.MatchString(label) || label == LABEL_PID || label == LABEL_MAIN_CLASS || label == LABEL_USER {
+					return fmt.Errorf("metric set '%s' item %d: invalid label name '%s'", name, i, label)
+				}
+			}
+		}
+	}
+
+	for command, defs := range c.Parsers {
+		if len(defs) == 0 {
+			return fmt.Errorf("parser for '%s' is empty", command)
+		}
+
+		for i := range defs {
+			if err := defs[i].compile(); err != nil {
+				return fmt.Errorf("parser for '%s' item %d: %v", command, i, err)
+			}
+		}
+	}
+
+	if len(c.Targets) == 0 && !c.Discovery.Enabled {
+		return fmt.Errorf("no targets defined and discovery is disabled")
+	}
+
+	if err := c.Discovery.prepare(&c.Defaults); err != nil {
+		return fmt.Errorf("discovery: %v", err)
+	}
+
+	if err := c.Perfdata.prepare(); err != nil {
+		return fmt.Errorf("perfdata: %v", err)
+	}
+
+	// validate the template as a discovered target would be
+	sample := c.Discovery.targetFor(&JvmInfo{Pid: 1, MainClass: "Main"})
+	if err := sample.validate(c.MetricSets, c.Parsers); err != nil {
+		return fmt.Errorf("discovery target: %v", err)
+	}
+
+	names := make(map[string]bool, len(c.Targets))
+
+	for i := range c.Targets {
+		t := &c.Targets[i]
+
+		t.applyDefaults(&c.Defaults)
+
+		if err := t.validate(c.MetricSets, c.Parsers); err != nil {
+			return fmt.Errorf("target %d (%s): %v", i, t.Name, err)
+		}
+
+		if names[t.Name] {
+			return fmt.Errorf("target %d: duplicate target name '%s'", i, t.Name)
+		}
+		names[t.Name] = true
+	}
+
+	errs, warnings := c.check()
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	c.warnings = warnings
+
+	return nil
+}
+
+func (t *TargetConfig) applyDefaults(d *TargetConfig) {
+
+	if t.Executor == "" {
+		t.Executor = d.Executor
+	}
+	if t.Executor == "" {
+		t.Executor = EXECUTOR_EXEC
+	}
+
+	if t.PathJcmd == "" {
+		t.PathJcmd = d.PathJcmd
+	}
+	if t.PathJcmd == "" {
+		t.PathJcmd = DEFAULT_PATH_JCMD
+	}
+
+	if t.SubSystem == "" {
+		t.SubSystem = d.SubSystem
+	}
+	if t.SubSystem == "" {
+		t.SubSystem = DEFAULT_SUBSYSTEM
+	}
+
+	if t.ExtraArgs == nil {
+		t.ExtraArgs = d.ExtraArgs
+	}
+
+	if t.TimerMs == 0 {
+		t.TimerMs = d.TimerMs
+	}
+	if t.TimerMs == 0 {
+		t.TimerMs = DEFAULT_TIMER_MS
+	}
+
+	if t.TimeoutMs == 0 {
+		t.TimeoutMs = d.TimeoutMs
+	}
+	if t.TimeoutMs == 0 {
+		t.TimeoutMs = DEFAULT_TIMEOUT_MS
+		if t.TimeoutMs > t.TimerMs {
+			t.TimeoutMs = t.TimerMs
+		}
+	}
+
+	// jitter spreads the first collection of each target over the whole
+	// interval unless set explicitly, 0 disables it
+	if t.JitterMs == nil {
+		t.JitterMs = d.JitterMs
+	}
+	if t.JitterMs == nil {
+		jitter := t.TimerMs
+		t.JitterMs = &jitter
+	}
+
+	if t.MissedTickPolicy == "" {
+		t.MissedTickPolicy = d.MissedTickPolicy
+	}
+	if t.MissedTickPolicy == "" {
+		t.MissedTickPolicy = MISSED_TICK_SKIP
+	}
+
+	if t.Mode == "" {
+		t.Mode = d.Mode
+	}
+	if t.Mode == "" {
+		t.Mode = MODE_BACKGROUND
+	}
+
+	if t.CacheMs == 0 {
+		t.CacheMs = d.CacheMs
+	}
+
+	// static labels of the defaults section are merged, target ones win
+	if len(d.Labels) > 0 {
+		labels := make(map[string]string, len(d.Labels)+len(t.Labels))
+		for k, v := range d.Labels {
+			labels[k] = v
+		}
+		for k, v := range t.Labels {
+			labels[k] = v
+		}
+		t.Labels = labels
+	}
+
+	if t.StaleAfter == 0 {
+		t.StaleAfter = d.StaleAfter
+	}
+	if t.StaleAfter == 0 {
+		t.StaleAfter = DEFAULT_STALE_AFTER
+	}
+
+	if t.TopN == 0 {
+		t.TopN = d.TopN
+	}
+	if t.TopN == 0 {
+		t.TopN = DEFAULT_TOP_N
+	}
+
+	if t.FrameDepth == 0 {
+		t.FrameDepth = d.FrameDepth
+	}
+	if t.FrameDepth == 0 {
+		t.FrameDepth = DEFAULT_FRAME_DEPTH
+	}
+
+	if t.PackageDepth == 0 {
+		t.PackageDepth = d.PackageDepth
+	}
+	if t.PackageDepth == 0 {
+		t.PackageDepth = DEFAULT_PACKAGE_DEPTH
+	}
+
+	if t.AllObjects == nil {
+		t.AllObjects = d.AllObjects
+	}
+	if t.AllObjects == nil {
+		allObjects := false
+		t.AllObjects = &allObjects
+	}
+
+	if t.MinIntervalMs == 0 {
+		t.MinIntervalMs = d.MinIntervalMs
+	}
+	// counting all objects runs no GC
+	if t.MinIntervalMs == 0 && t.SubSystem == CLASS_HISTOGRAM_COMMAND && !*t.AllObjects {
+		t.MinIntervalMs = DEFAULT_CLASS_HISTOGRAM_MIN_INTERVAL_MS
+	}
+
+	if t.Baseline == nil {
+		t.Baseline = d.Baseline
+	}
+	if t.Baseline == nil {
+		baseline := false
+		t.Baseline = &baseline
+	}
+
+	if t.BaselineIntervalMs == 0 {
+		t.BaselineIntervalMs = d.BaselineIntervalMs
+	}
+
+	if t.Metrics == "" {
+		t.Metrics = d.Metrics
+	}
+	if t.Metrics == "" {
+		t.Metrics = commandMetricSets[t.SubSystem]
+	}
+	if t.Metrics == "" {
+		t.Metrics = DEFAULT_METRICS_SET
+	}
+
+	if t.Name == "" {
+		if t.MainClass != "" {
+			t.Name = t.MainClass
+		} else if t.Pid > 0 {
+			t.Name = strconv.Itoa(t.Pid)
+		}
+	}
+}
+
+func (t *TargetConfig) validate(sets map[string][]MetricDescAttr, parsers map[string][]ParserDef) error {
+
+	if t.MainClass == "" && t.Pid == 0 {
+		return fmt.Errorf("one of main_class or pid is required")
+	}
+
+	if t.MainClass != "" && t.Pid != 0 {
+		return fmt.Errorf("main_class and pid are mutually exclusive")
+	}
+
+	if t.Pid < 0 {
+		return fmt.Errorf("pid must be positive, got %d", t.Pid)
+	}
+
+	if t.TimerMs < 0 || t.TimeoutMs < 0 {
+		return fmt.Errorf("timer_ms and timeout_ms must be positive")
+	}
+
+	if *t.JitterMs < 0 || *t.JitterMs > t.TimerMs {
+		return fmt.Errorf("jitter_ms must be between 0 and timer_ms (%d), got %d", t.TimerMs, *t.JitterMs)
+	}
+
+	if t.MissedTickPolicy != MISSED_TICK_SKIP && t.MissedTickPolicy != MISSED_TICK_CATCH_UP {
+		return fmt.Errorf("missed_tick_policy must be '%s' or '%s', got '%s'", MISSED_TICK_SKIP, MISSED_TICK_CATCH_UP, t.MissedTickPolicy)
+	}
+
+	if t.Executor != EXECUTOR_EXEC && t.Executor != EXECUTOR_ATTACH && t.Executor != EXECUTOR_NONE {
+		return fmt.Errorf("executor must be '%s', '%s' or '%s', got '%s'", EXECUTOR_EXEC, EXECUTOR_ATTACH, EXECUTOR_NONE, t.Executor)
+	}
+
+	// executor "none" runs no command, so it needs no parser either
+	if t.Executor != EXECUTOR_NONE && !HasParser(t.SubSystem, parsers) {
+		return fmt.Errorf("no parser for subsystem %s, define one in parsers", t.SubSystem)
+	}
+
+	if t.Mode != MODE_BACKGROUND && t.Mode != MODE_SCRAPE {
+		return fmt.Errorf("mode must be '%s' or '%s', got '%s'", MODE_BACKGROUND, MODE_SCRAPE, t.Mode)
+	}
+
+	if t.CacheMs < 0 {
+		return fmt.Errorf("cache_ms must be positive, got %d", t.CacheMs)
+	}
+
+	if t.StaleAfter < 0 {
+		return fmt.Errorf("stale_after must be positive, got %d", t.StaleAfter)
+	}
+
+	if t.TopN < 0 || t.FrameDepth < 0 || t.PackageDepth < 0 {
+		return fmt.Errorf("top_n, frame_depth and package_depth must be positive")
+	}
+
+	if t.MinIntervalMs < 0 {
+		return fmt.Errorf("min_interval_ms must be positive, got %d", t.MinIntervalMs)
+	}
+
+	if *t.AllObjects && t.SubSystem != CLASS_HISTOGRAM_COMMAND {
+		return fmt.Errorf("all_objects needs subsystem %s", CLASS_HISTOGRAM_COMMAND)
+	}
+
+	if *t.Baseline {
+		if t.SubSystem != NMT_COMMAND || t.Executor == EXECUTOR_NONE {
+			return fmt.Errorf("baseline needs subsystem %s and an executor", NMT_COMMAND)
+		}
+		for _, arg := range t.ExtraArgs {
+			if arg == NMT_DETAIL {
+				return fmt.Errorf("baseline works with summary only")
+			}
+		}
+	}
+
+	if t.BaselineIntervalMs < 0 {
+		return fmt.Errorf("baseline_interval_ms must be positive, got %d", t.BaselineIntervalMs)
+	}
+
+	if t.TimeoutMs > t.TimerMs {
+		return fmt.Errorf("timeout_ms (%d) is greater than timer_ms (%d)", t.TimeoutMs, t.TimerMs)
+	}
+
+	for name := range t.Labels {
+		if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name '%s'", name)
+		}
+		if name == LABEL_PID || name == LABEL_MAIN_CLASS || name == LABEL_USER {
+			return fmt.Errorf("label '%s' is reserved", name)
+		}
+	}
+
+	if _, ok := sets[t.Metrics]; !ok {
+		return fmt.Errorf("unknown metric set '%s'", t.Metrics)
+	}
+
+	return nil
+}
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// NewJcmdTask creates a task from a target definition with its own series
+// in the vectors of set. The JVM labels are resolved through hsperfdata
+// found in the discovery hsperfdata_dir of config, the parser is the one
+// config defines for the subsystem, if any.
+func NewJcmdTask(t *TargetConfig, set *registeredSet, config *Config) *JcmdTask {
+
+	hsperfdataDir := config.Discovery.HsperfdataDir
+
+	task := &JcmdTask{
+		Name:      t.Name,
+		PathJcmd:  t.PathJcmd,
+		ExtraArgs: t.ExtraArgs,
+		MainClass: t.MainClass,
+		Pid:       t.Pid,
+		SubSystem: t.SubSystem,
+		TimerMs:   t.TimerMs,
+		TimeoutMs: t.TimeoutMs,
+		JitterMs:  *t.JitterMs,
+
+		MissedTickPolicy: t.MissedTickPolicy,
+
+		Mode:    t.Mode,
+		CacheMs: t.CacheMs,
+
+		HsperfdataDir: hsperfdataDir,
+		Labels:        t.Labels,
+		labelNames:    set.labelNames,
+
+		StaleAfter: t.StaleAfter,
+
+		TopN:         t.TopN,
+		FrameDepth:   t.FrameDepth,
+		PackageDepth: t.PackageDepth,
+
+		MinIntervalMs: t.MinIntervalMs,
+		AllObjects:    *t.AllObjects,
+
+		Baseline:           *t.Baseline,
+		BaselineIntervalMs: t.BaselineIntervalMs,
+	}
+
+	switch t.Executor {
+	case EXECUTOR_ATTACH:
+		task.Executor = &attachExecutor{hsperfdataDir: hsperfdataDir}
+	case EXECUTOR_EXEC:
+		task.Executor = &execExecutor{path: t.PathJcmd}
+	}
+
+	task.Parser = NewParser(task, config.Parsers)
+
+	task.labels, task.jvm = task.resolveLabels()
+	task.Metrics = set.vecs.NewMetricsMap(task.labels)
+
+	return task
+}
+
+// SetLabelNames returns the label names of a metric set: the JVM labels
+// followed by the sorted union of the static labels of its targets.
+func SetLabelNames(targets []TargetConfig, set string) []string {
+
+	static := make(map[string]bool)
+	for _, t := range targets {
+		if t.Metrics != set {
+			continue
+		}
+		for name := range t.Labels {
+			static[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(static))
+	for name := range static {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return append([]string{LABEL_PID, LABEL_MAIN_CLASS, LABEL_USER}, names...)
+}
+
+// setConverters returns the definitions of the converters a metric set
+// uses, the set is registered again when one of them changes.
+func setConverters(attrs []MetricDescAttr, defs map[string]ConverterDef) map[string]ConverterDef {
+
+	used := make(map[string]ConverterDef)
+	for _, attr := range attrs {
+		if def, ok := defs[attr.Convert]; ok {
+			used[attr.Convert] = def
+		}
+	}
+
+	return used
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file into a temporary directory and returns
+// its path.
+func writeConfig(t *testing.T, content string) string {
+
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+
+	c, err := LoadConfig(writeConfig(t, `
+defaults:
+  timer_ms: 20000
+  labels:
+    env: dev
+    team: a
+targets:
+  - main_class: com.example.App
+    labels:
+      team: b
+  - pid: 12345
+    jcmd_path: /opt/java/bin/jcmd
+    timer_ms: 1000
+    jitter_ms: 0
+  - name: heap
+    pid: 12346
+    subsystem: GC.heap_info
+    extra_args: []
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Targets) != 3 {
+		t.Fatalf("%d targets, want 3", len(c.Targets))
+	}
+
+	app, pid, heap := c.Targets[0], c.Targets[1], c.Targets[2]
+
+	if app.Name != "com.example.App" || pid.Name != "12345" || heap.Name != "heap" {
+		t.Errorf("names %q, %q, %q", app.Name, pid.Name, heap.Name)
+	}
+
+	if app.Executor != EXECUTOR_EXEC || app.PathJcmd != DEFAULT_PATH_JCMD || pid.PathJcmd != "/opt/java/bin/jcmd" {
+		t.Errorf("executor %q, jcmd paths %q and %q", app.Executor, app.PathJcmd, pid.PathJcmd)
+	}
+
+	if app.SubSystem != DEFAULT_SUBSYSTEM || app.Metrics != DEFAULT_METRICS_SET || heap.Metrics != HEAP_INFO_METRICS_SET {
+		t.Errorf("subsystem %q, metric sets %q and %q", app.SubSystem, app.Metrics, heap.Metrics)
+	}
+
+	// the timeout is capped by a shorter timer, the jitter spans the timer
+	if app.TimerMs != 20000 || app.TimeoutMs != DEFAULT_TIMEOUT_MS || *app.JitterMs != 20000 {
+		t.Errorf("app timer %d, timeout %d, jitter %d", app.TimerMs, app.TimeoutMs, *app.JitterMs)
+	}
+	if pid.TimerMs != 1000 || pid.TimeoutMs != 1000 || *pid.JitterMs != 0 {
+		t.Errorf("pid timer %d, timeout %d, jitter %d", pid.TimerMs, pid.TimeoutMs, *pid.JitterMs)
+	}
+
+	if want := map[string]string{"env": "dev", "team": "b"}; !reflect.DeepEqual(app.Labels, want) {
+		t.Errorf("labels %v, want %v", app.Labels, want)
+	}
+
+	if app.MissedTickPolicy != MISSED_TICK_SKIP || app.Mode != MODE_BACKGROUND || app.StaleAfter != DEFAULT_STALE_AFTER {
+		t.Errorf("policy %q, mode %q, stale_after %d", app.MissedTickPolicy, app.Mode, app.StaleAfter)
+	}
+}
+
+func TestLoadConfigJson(t *testing.T) {
+
+	c, err := LoadConfig(writeConfig(t, `{"targets": [{"name": "app", "pid": 42, "extra_args": ["summary", "scale=MB"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := c.Targets[0]; got.Name != "app" || got.Pid != 42 || !reflect.DeepEqual(got.ExtraArgs, []string{"summary", "scale=MB"}) {
+		t.Errorf("target %+v", got)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{"no targets", `targets: []`, "no targets defined"},
+		{"unknown field", "targets:\n  - pid: 1\n    timer: 5", "field timer not found"},
+		{"no jvm", "targets:\n  - name: a", "one of main_class or pid is required"},
+		{"main class and pid", "targets:\n  - pid: 1\n    main_class: App", "mutually exclusive"},
+		{"duplicate name", "targets:\n  - pid: 1\n    name: a\n  - pid: 2\n    name: a", "duplicate target name 'a'"},
+		{"timeout", "targets:\n  - pid: 1\n    timer_ms: 1000\n    timeout_ms: 2000", "timeout_ms (2000) is greater than timer_ms (1000)"},
+		{"jitter", "targets:\n  - pid: 1\n    timer_ms: 1000\n    jitter_ms: 2000", "jitter_ms must be between 0 and timer_ms"},
+		{"policy", "targets:\n  - pid: 1\n    missed_tick_policy: later", "missed_tick_policy must be"},
+		{"executor", "targets:\n  - pid: 1\n    executor: ssh", "executor must be"},
+		{"mode", "targets:\n  - pid: 1\n    mode: push", "mode must be"},
+		{"reserved label", "targets:\n  - pid: 1\n    labels: {pid: x}", "label 'pid' is reserved"},
+		{"label name", "targets:\n  - pid: 1\n    labels: {a-b: x}", "invalid label name 'a-b'"},
+		{"metric set", "targets:\n  - pid: 1\n    metrics: nope", "unknown metric set 'nope'"},
+	}
+
+	for _, tt := range tests {
+		_, err := LoadConfig(writeConfig(t, tt.config))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yml")); err == nil {
+		t.Error("no error for a missing file")
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+
+	c, err := DefaultConfig("com.example.App")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Targets) != 1 || c.Targets[0].MainClass != "com.example.App" || c.Targets[0].Metrics != DEFAULT_METRICS_SET {
+		t.Errorf("targets %+v", c.Targets)
+	}
+}
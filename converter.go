@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TICKS_PER_SECOND is the frequency of the HotSpot elapsed counter on Linux,
+// other frequencies can be defined as converters with a scale.
+const TICKS_PER_SECOND = 1e9
+
+var converters = make(map[string]ConvertFunction)
+
+// RegisterConverter makes fn available to metric sets as convert name.
+func RegisterConverter(name string, fn ConvertFunction) {
+
+	converters[name] = fn
+}
+
+func init() {
+	RegisterConverter("", ConvertFnBasic)
+	RegisterConverter("number", ConvertFnBasic)
+	RegisterConverter("kb_to_bytes", ConvertFnKbToBytes)
+	RegisterConverter("bytes", ConvertFnBytes)
+	RegisterConverter("percent", ConvertFnPercent)
+	RegisterConverter("percent_to_ratio", ConvertFnPercentToRatio)
+	RegisterConverter("duration_to_seconds", ConvertFnDurationToSeconds)
+	RegisterConverter("ticks_to_seconds", ConvertFnTicksToSeconds)
+	RegisterConverter("bool", ConvertFnBool)
+}
+
+// LookupConverter returns the converter defined in the config, else the
+// registered one.
+func LookupConverter(name string, defined map[string]ConvertFunction) (ConvertFunction, error) {
+
+	if fn, ok := defined[name]; ok {
+		return fn, nil
+	}
+
+	if fn, ok := converters[name]; ok {
+		return fn, nil
+	}
+
+	names := make([]string, 0, len(converters)+len(defined))
+	for n := range converters {
+		if n != "" {
+			names = append(names, n)
+		}
+	}
+	for n := range defined {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return nil, fmt.Errorf("unknown convert '%s', known are %s", name, strings.Join(names, ", "))
+}
+
+// compile returns the converter of the definition. A map converts the
+// values it lists, anything else is converted by the registered converter
+// convert and multiplied by scale.
+func (d *ConverterDef) compile() (ConvertFunction, error) {
+
+	if len(d.Map) > 0 {
+		if d.Convert != "" || d.Scale != nil {
+			return nil, fmt.Errorf("map can not be combined with convert or scale")
+		}
+
+		values := d.Map
+		def := d.Default
+
+		return func(v string) (float64, error) {
+
+			if value, ok := values[strings.TrimSpace(v)]; ok {
+				return value, nil
+			}
+			if def != nil {
+				return *def, nil
+			}
+
+			return 0, fmt.Errorf("value '%s' not in map", v)
+		}, nil
+	}
+
+	if d.Default != nil {
+		return nil, fmt.Errorf("default requires a map")
+	}
+
+	base, ok := converters[d.Convert]
+	if !ok {
+		return nil, fmt.Errorf("unknown convert '%s'", d.Convert)
+	}
+
+	if d.Scale == nil {
+		return base, nil
+	}
+
+	if *d.Scale == 0 {
+		return nil, fmt.Errorf("scale must not be 0")
+	}
+
+	scale := *d.Scale
+
+	return func(v string) (float64, error) {
+
+		value, err := base(v)
+		if err != nil {
+			return 0, err
+		}
+
+		return value * scale, nil
+	}, nil
+}
+
+func ConvertFnBasic(v string) (float64, error) {
+
+	return strconv.ParseFloat(v, 64)
+}
+
+// ConvertFnKbToBytes converts a size to bytes, a bare number is in KB.
+func ConvertFnKbToBytes(v string) (float64, error) {
+
+	return ParseSize(v, "KB")
+}
+
+// ConvertFnBytes converts a size to bytes, a bare number is in bytes.
+func ConvertFnBytes(v string) (float64, error) {
+
+	return ParseSize(v, "B")
+}
+
+var percentRE = regexp.MustCompile(`^([+-]?\d+(?:[.,]\d+)?)\s*%?$`)
+
+// ConvertFnPercent converts a percentage like "12.5%" or "0,00%" to its
+// number.
+func ConvertFnPercent(v string) (float64, error) {
+
+	m := percentRE.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return 0, fmt.Errorf("invalid percentage '%s'", v)
+	}
+
+	return strconv.ParseFloat(strings.Replace(m[1], ",", ".", 1), 64)
+}
+
+// ConvertFnPercentToRatio converts a percentage to a ratio, "50%" is 0.5.
+func ConvertFnPercentToRatio(v string) (float64, error) {
+
+	value, err := ConvertFnPercent(v)
+
+	return value / 100, err
+}
+
+var (
+	durationRE    = regexp.MustCompile(`^([+-]?\d+(?:[.,]\d+)?)\s*(ns|us|µs|ms|s|m|min|h|d)?$`)
+	durationUnits = map[string]float64{
+		"ns":  1e-9,
+		"us":  1e-6,
+		"µs":  1e-6,
+		"ms":  1e-3,
+		"":    1,
+		"s":   1,
+		"m":   60,
+		"min": 60,
+		"h":   3600,
+		"d":   86400,
+	}
+)
+
+// ConvertFnDurationToSeconds converts a duration like "12.3s", "45ms" or
+// "1234.567 s" to seconds, a bare number is in seconds.
+func ConvertFnDurationToSeconds(v string) (float64, error) {
+
+	m := durationRE.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return 0, fmt.Errorf("invalid duration '%s'", v)
+	}
+
+	value, err := strconv.ParseFloat(strings.Replace(m[1], ",", ".", 1), 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return value * durationUnits[m[2]], nil
+}
+
+// ConvertFnTicksToSeconds converts ticks of the elapsed counter to seconds.
+func ConvertFnTicksToSeconds(v string) (float64, error) {
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return value / TICKS_PER_SECOND, nil
+}
+
+// ConvertFnBool converts true/false, yes/no, on/off, enabled/disabled and
+// 1/0 to 1 or 0.
+func ConvertFnBool(v string) (float64, error) {
+
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "true", "yes", "on", "enabled", "1":
+		return 1, nil
+	case "false", "no", "off", "disabled", "0":
+		return 0, nil
+	}
+
+	return 0, fmt.Errorf("invalid boolean '%s'", v)
+}
+
+var (
+	sizeRE    = regexp.MustCompile(`^([+-]?\d+(?:\.\d+)?)\s*([KMGT]?B)?$`)
+	sizeUnits = map[string]float64{
+		"B":  1,
+		"KB": 1 << 10,
+		"MB": 1 << 20,
+		"GB": 1 << 30,
+		"TB": 1 << 40,
+	}
+)
+
+// ParseSize converts a size like "12KB", "-3MB" or "512B" to bytes. The
+// unit printed next to the number wins, defaultUnit applies to a bare
+// number only.
+func ParseSize(v string, defaultUnit string) (float64, error) {
+
+	m := sizeRE.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size '%s'", v)
+	}
+
+	value, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	unit := m[2]
+	if unit == "" {
+		unit = defaultUnit
+	}
+
+	return value * sizeUnits[unit], nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestConverterDefCompile(t *testing.T) {
+
+	scale := func(f float64) *float64 { return &f }
+
+	states := map[string]float64{"enabled": 1, "disabled": 0}
+
+	tests := []struct {
+		name  string
+		def   ConverterDef
+		value string
+		want  float64
+		// compile or convert fails
+		compileErr bool
+		convertErr bool
+	}{
+		{name: "map", def: ConverterDef{Map: states}, value: " enabled ", want: 1},
+		{name: "map miss", def: ConverterDef{Map: states}, value: "unknown", convertErr: true},
+		{name: "map default", def: ConverterDef{Map: states, Default: scale(-1)}, value: "unknown", want: -1},
+		{name: "convert", def: ConverterDef{Convert: "kb_to_bytes"}, value: "2KB", want: 2048},
+		{name: "scale", def: ConverterDef{Convert: "number", Scale: scale(0.001)}, value: "1500", want: 1.5},
+		{name: "scale default convert", def: ConverterDef{Scale: scale(1024)}, value: "2", want: 2048},
+		{name: "scale error", def: ConverterDef{Convert: "number", Scale: scale(2)}, value: "x", convertErr: true},
+		{name: "scale 0", def: ConverterDef{Scale: scale(0)}, compileErr: true},
+		{name: "default without map", def: ConverterDef{Default: scale(1)}, compileErr: true},
+		{name: "map and convert", def: ConverterDef{Map: states, Convert: "number"}, compileErr: true},
+		{name: "unknown convert", def: ConverterDef{Convert: "furlongs"}, compileErr: true},
+	}
+
+	for _, tt := range tests {
+
+		fn, err := tt.def.compile()
+		if (err != nil) != tt.compileErr {
+			t.Errorf("%s: compile error %v", tt.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		got, err := fn(tt.value)
+		if (err != nil) != tt.convertErr {
+			t.Errorf("%s: convert error %v", tt.name, err)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("%s: %q converted to %v, want %v", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DEFAULT_DISCOVERY_INTERVAL_MS = 10000
+	DEFAULT_HSPERFDATA_DIR        = "/tmp"
+
+	// jcmd, jps and friends are JVMs too, never monitor them
+	DEFAULT_DISCOVERY_EXCLUDE = `^(jdk\.jcmd/)?sun\.tools\.`
+
+	PERFDATA_JAVA_COMMAND = "sun.rt.javaCommand"
+)
+
+// FindJvms lists running JVMs the same way jps does: every file named after
+// a pid in <dir>/hsperfdata_<user>/ belongs to a JVM started by <user>.
+// With containers set, JVMs in other mount namespaces are found too, through
+// /proc/<pid>/root, and reported with their host pid.
+func FindJvms(dir string, containers bool) ([]JvmInfo, error) {
+
+	userDirs, err := filepath.Glob(filepath.Join(dir, "hsperfdata_*"))
+	if err != nil {
+		return nil, err
+	}
+
+	jvms := make([]JvmInfo, 0)
+	seen := make(map[int]bool)
+
+	for _, userDir := range userDirs {
+
+		files, err := ioutil.ReadDir(userDir)
+		if err != nil {
+			log.Printf("ERROR can not read %s - %v\n", userDir, err)
+			continue
+		}
+
+		for _, f := range files {
+
+			pid, err := strconv.Atoi(f.Name())
+			if err != nil || pid <= 0 || f.IsDir() {
+				continue
+			}
+
+			// files of crashed JVMs are left behind
+			if !processAlive(pid) {
+				continue
+			}
+
+			jvm, err := ReadJvmInfo(filepath.Join(userDir, f.Name()))
+			if err != nil {
+				log.Printf("ERROR can not read perfdata of pid %d - %v\n", pid, err)
+				continue
+			}
+
+			jvm.Pid = pid
+			jvms = append(jvms, *jvm)
+			seen[pid] = true
+		}
+	}
+
+	if !containers {
+		return jvms, nil
+	}
+
+	for _, ns := range hostNsPids() {
+		if seen[ns.Pid] {
+			continue
+		}
+
+		if jvm := findNsJvm(dir, ns); jvm != nil {
+			jvms = append(jvms, *jvm)
+		}
+	}
+
+	return jvms, nil
+}
+
+func findNsJvm(dir string, ns *ProcessNs) *JvmInfo {
+
+	paths, _ := filepath.Glob(filepath.Join(ns.Path(dir), "hsperfdata_*", strconv.Itoa(ns.NsPid)))
+	if len(paths) != 1 {
+		return nil
+	}
+
+	jvm, err := ReadJvmInfo(paths[0])
+	if err != nil {
+		return nil
+	}
+
+	jvm.Pid = ns.Pid
+
+	return jvm
+}
+
+// FindJvm looks up a single JVM by pid or, when pid is 0, by main class the
+// way jcmd matches it (full name or the simple class name). It returns nil
+// when nothing or more than one JVM matches.
+func FindJvm(dir string, pid int, mainClass string) *JvmInfo {
+
+	if pid > 0 {
+		ns, err := GetProcessNs(pid)
+		if err != nil {
+			return nil
+		}
+
+		return findNsJvm(dir, ns)
+	}
+
+	jvms, err := FindJvms(dir, false)
+	if err != nil {
+		return nil
+	}
+
+	var found *JvmInfo
+
+	for i := range jvms {
+		name := jvms[i].MainClass
+		if name != mainClass && !strings.HasSuffix(name, "."+mainClass) {
+			continue
+		}
+
+		if found != nil {
+			return nil
+		}
+		found = &jvms[i]
+	}
+
+	return found
+}
+
+func ReadJvmInfo(path string) (*JvmInfo, error) {
+
+	entries, err := ReadPerfDataFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.Name == PERFDATA_JAVA_COMMAND {
+			fields := strings.SplitN(strings.TrimSpace(e.String), " ", 2)
+
+			jvm := JvmInfo{
+				MainClass: fields[0],
+				User:      strings.TrimPrefix(filepath.Base(filepath.Dir(path)), "hsperfdata_"),
+				Path:      path,
+			}
+			if len(fields) > 1 {
+				jvm.Args = fields[1]
+			}
+
+			return &jvm, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s not found", PERFDATA_JAVA_COMMAND)
+}
+
+func processAlive(pid int) bool {
+
+	err := syscall.Kill(pid, 0)
+
+	return err == nil || err == syscall.EPERM
+}
+
+func (d *DiscoveryConfig) prepare(defaults *TargetConfig) error {
+
+	if d.IntervalMs == 0 {
+		d.IntervalMs = DEFAULT_DISCOVERY_INTERVAL_MS
+	}
+	if d.IntervalMs < 0 {
+		return fmt.Errorf("interval_ms must be positive, got %d", d.IntervalMs)
+	}
+
+	if d.HsperfdataDir == "" {
+		d.HsperfdataDir = DEFAULT_HSPERFDATA_DIR
+	}
+
+	if d.Exclude == nil {
+		d.Exclude = []string{DEFAULT_DISCOVERY_EXCLUDE}
+	}
+
+	var err error
+
+	if d.include, err = compileRegexps(d.Include); err != nil {
+		return fmt.Errorf("include: %v", err)
+	}
+
+	if d.exclude, err = compileRegexps(d.Exclude); err != nil {
+		return fmt.Errorf("exclude: %v", err)
+	}
+
+	if d.Target.Name != "" || d.Target.MainClass != "" || d.Target.Pid != 0 {
+		return fmt.Errorf("target template must not set name, main_class or pid")
+	}
+
+	d.Target.applyDefaults(defaults)
+
+	return nil
+}
+
+func compileRegexps(patterns []string) ([]*regexp.Regexp, error) {
+
+	res := make([]*regexp.Regexp, 0, len(patterns))
+
+	for _, s := range patterns {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad regex '%s' - %v", s, err)
+		}
+		res = append(res, re)
+	}
+
+	return res, nil
+}
+
+// match applies the include and exclude regexes to the java command line,
+// i.e. the main class (or jar) followed by the program arguments.
+func (d *DiscoveryConfig) match(jvm *JvmInfo) bool {
+
+	command := strings.TrimSpace(jvm.MainClass + " " + jvm.Args)
+
+	for _, re := range d.exclude {
+		if re.MatchString(command) {
+			return false
+		}
+	}
+
+	if len(d.include) == 0 {
+		return true
+	}
+
+	for _, re := range d.include {
+		if re.MatchString(command) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *DiscoveryConfig) targetFor(jvm *JvmInfo) TargetConfig {
+
+	t := d.Target
+	t.Name = fmt.Sprintf("%s@%d", jvm.MainClass, jvm.Pid)
+	t.Pid = jvm.Pid
+
+	return t
+}
+
+// discover rescans hsperfdata and returns whether the discovered target set
+// has changed. Must be called with a.mu held.
+func (a *Application) discover() bool {
+
+	discovered := make(map[string]TargetConfig)
+
+	d := &a.config.Discovery
+
+	if d.Enabled {
+		jvms, err := FindJvms(d.HsperfdataDir, d.Containers)
+		if err != nil {
+			log.Printf("ERROR discovery failed - %v\n", err)
+			return false
+		}
+
+		// JVMs of configured targets are left to them, main class targets
+		// are resolved like their tasks do, or both would write the same
+		// series
+		static := make(map[int]bool)
+		for _, t := range a.config.Targets {
+			if t.Pid > 0 {
+				static[t.Pid] = true
+			} else if jvm := FindJvm(d.HsperfdataDir, 0, t.MainClass); jvm != nil {
+				static[jvm.Pid] = true
+			}
+		}
+
+		for i := range jvms {
+			if static[jvms[i].Pid] || !d.match(&jvms[i]) {
+				continue
+			}
+
+			t := d.targetFor(&jvms[i])
+			discovered[t.Name] = t
+		}
+	}
+
+	if reflect.DeepEqual(discovered, a.discovered) {
+		return false
+	}
+
+	a.discovered = discovered
+
+	return true
+}
+
+// RunDiscovery periodically rescans for JVMs and starts or stops their tasks.
+func (a *Application) RunDiscovery() {
+
+	go func() {
+		for {
+			a.mu.Lock()
+			interval := time.Duration(a.config.Discovery.IntervalMs) * time.Millisecond
+			a.mu.Unlock()
+
+			select {
+			case <-a.ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+
+			a.mu.Lock()
+			discovered := a.discovered
+			if a.discover() {
+				// retried with the next scan
+				if err := a.apply(); err != nil {
+					a.discovered = discovered
+					log.Printf("ERROR applying discovered targets - %v\n", err)
+				}
+			}
+			a.mu.Unlock()
+		}
+	}()
+}
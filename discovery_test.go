@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// perfEntry encodes an entry of a little endian hsperfdata file, padded to
+// 8 bytes.
+func perfEntry(name string, typ byte, units byte, vectorLength int, value []byte) []byte {
+
+	dataOffset := PERFDATA_ENTRY_SIZE + len(name) + 1
+	dataOffset = (dataOffset + 7) &^ 7
+	length := (dataOffset + len(value) + 7) &^ 7
+
+	e := make([]byte, length)
+	binary.LittleEndian.PutUint32(e[0:4], uint32(length))
+	binary.LittleEndian.PutUint32(e[4:8], PERFDATA_ENTRY_SIZE)
+	binary.LittleEndian.PutUint32(e[8:12], uint32(vectorLength))
+	e[12] = typ
+	e[14] = units
+	e[15] = PERFDATA_VARIABILITY_CONSTANT
+	binary.LittleEndian.PutUint32(e[16:20], uint32(dataOffset))
+	copy(e[PERFDATA_ENTRY_SIZE:], name)
+	copy(e[dataOffset:], value)
+
+	return e
+}
+
+func perfData(entries ...[]byte) []byte {
+
+	prologue := make([]byte, PERFDATA_PROLOGUE_SIZE)
+	binary.BigEndian.PutUint32(prologue[0:4], PERFDATA_MAGIC)
+	prologue[4] = 1 // little endian
+	prologue[5] = 2
+	binary.LittleEndian.PutUint32(prologue[24:28], PERFDATA_PROLOGUE_SIZE)
+	binary.LittleEndian.PutUint32(prologue[28:32], uint32(len(entries)))
+
+	return append(prologue, bytes.Join(entries, nil)...)
+}
+
+// writeHsperfdata writes the hsperfdata file of a JVM with pid started by
+// user running command into dir.
+func writeHsperfdata(t *testing.T, dir string, user string, pid int, command string) {
+
+	t.Helper()
+
+	userDir := filepath.Join(dir, "hsperfdata_"+user)
+	if err := os.MkdirAll(userDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	value := []byte(command + "\x00")
+	data := perfData(perfEntry(PERFDATA_JAVA_COMMAND, PERFDATA_TYPE_BYTE, PERFDATA_UNITS_STRING, len(value), value))
+
+	if err := ioutil.WriteFile(filepath.Join(userDir, strconv.Itoa(pid)), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a pid above the highest pid_max, never alive
+const deadPid = 4194305
+
+func TestFindJvms(t *testing.T) {
+
+	dir := t.TempDir()
+	writeHsperfdata(t, dir, "app", os.Getpid(), "com.example.App --port 8080")
+	writeHsperfdata(t, dir, "batch", os.Getppid(), "/opt/batch.jar")
+	writeHsperfdata(t, dir, "app", deadPid, "com.example.Crashed")
+	writeHsperfdata(t, dir, "app", 0, "com.example.Zero")
+	ioutil.WriteFile(filepath.Join(dir, "hsperfdata_app", "notes"), []byte("x"), 0644)
+
+	jvms, err := FindJvms(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Slice(jvms, func(i, j int) bool { return jvms[i].User < jvms[j].User })
+	for i := range jvms {
+		jvms[i].Path = ""
+	}
+
+	want := []JvmInfo{
+		{Pid: os.Getpid(), User: "app", MainClass: "com.example.App", Args: "--port 8080"},
+		{Pid: os.Getppid(), User: "batch", MainClass: "/opt/batch.jar"},
+	}
+
+	if !reflect.DeepEqual(jvms, want) {
+		t.Errorf("jvms %+v, want %+v", jvms, want)
+	}
+}
+
+func TestFindJvm(t *testing.T) {
+
+	dir := t.TempDir()
+	writeHsperfdata(t, dir, "app", os.Getpid(), "com.example.App")
+
+	for _, mainClass := range []string{"com.example.App", "App"} {
+		if jvm := FindJvm(dir, 0, mainClass); jvm == nil || jvm.Pid != os.Getpid() {
+			t.Errorf("%s: found %+v", mainClass, jvm)
+		}
+	}
+
+	if jvm := FindJvm(dir, 0, "example.Other"); jvm != nil {
+		t.Errorf("found %+v for another main class", jvm)
+	}
+
+	// jcmd does not pick one of several JVMs of a main class either
+	writeHsperfdata(t, dir, "other", os.Getppid(), "org.example.App")
+	if jvm := FindJvm(dir, 0, "App"); jvm != nil {
+		t.Errorf("found %+v for an ambiguous main class", jvm)
+	}
+}
+
+func TestDiscoveryMatch(t *testing.T) {
+
+	tests := []struct {
+		include []string
+		exclude []string
+		command string
+		want    bool
+	}{
+		{nil, nil, "com.example.App", true},
+		// jcmd itself is excluded by default
+		{nil, nil, "jdk.jcmd/sun.tools.jcmd.JCmd 12345 VM.version", false},
+		{[]string{`^com\.example\.`}, nil, "com.example.App", true},
+		{[]string{`^com\.example\.`}, nil, "org.example.App", false},
+		// the arguments are matched too
+		{[]string{`--port 8080`}, nil, "com.example.App --port 8080", true},
+		// exclude wins over include
+		{[]string{`^com\.`}, []string{`Batch$`}, "com.example.Batch", false},
+		{nil, []string{}, "jdk.jcmd/sun.tools.jcmd.JCmd", true},
+	}
+
+	for _, tt := range tests {
+
+		d := DiscoveryConfig{Include: tt.include, Exclude: tt.exclude}
+		if err := d.prepare(&TargetConfig{}); err != nil {
+			t.Fatal(err)
+		}
+
+		fields := strings.SplitN(tt.command, " ", 2)
+		jvm := JvmInfo{MainClass: fields[0]}
+		if len(fields) > 1 {
+			jvm.Args = fields[1]
+		}
+
+		if got := d.match(&jvm); got != tt.want {
+			t.Errorf("include %v, exclude %v: %q matched %v, want %v", tt.include, tt.exclude, tt.command, got, tt.want)
+		}
+	}
+
+	if err := (&DiscoveryConfig{Include: []string{"("}}).prepare(&TargetConfig{}); err == nil {
+		t.Error("no error for a bad regex")
+	}
+}
+
+func TestDiscover(t *testing.T) {
+
+	dir := t.TempDir()
+	writeHsperfdata(t, dir, "app", os.Getpid(), "com.example.App")
+	writeHsperfdata(t, dir, "app", os.Getppid(), "com.example.Other")
+
+	tests := []struct {
+		targets string
+		want    []string
+	}{
+		{"", []string{fmt.Sprintf("com.example.App@%d", os.Getpid()), fmt.Sprintf("com.example.Other@%d", os.Getppid())}},
+		// JVMs of configured targets, by pid or by main class, are left to them
+		{fmt.Sprintf("\n  - pid: %d", os.Getpid()), []string{fmt.Sprintf("com.example.Other@%d", os.Getppid())}},
+		{"\n  - main_class: App", []string{fmt.Sprintf("com.example.Other@%d", os.Getppid())}},
+	}
+
+	for _, tt := range tests {
+
+		c, err := LoadConfig(writeConfig(t, fmt.Sprintf(`
+discovery:
+  enabled: true
+  hsperfdata_dir: %s
+  target:
+    timer_ms: 15000
+targets:%s
+`, dir, tt.targets)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a := &Application{config: c}
+		a.discover()
+
+		names := make([]string, 0, len(a.discovered))
+		for name, target := range a.discovered {
+			names = append(names, name)
+			if target.TimerMs != 15000 {
+				t.Errorf("%s: timer_ms %d of the template not applied", name, target.TimerMs)
+			}
+		}
+		sort.Strings(names)
+
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("targets %q: discovered %v, want %v", tt.targets, names, tt.want)
+		}
+	}
+}
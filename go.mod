@@ -2,7 +2,10 @@ module github.com/iaroslavscript/jcmd-exporter
 
 go 1.17
 
-require github.com/prometheus/client_golang v1.11.0
+require (
+	github.com/prometheus/client_golang v1.11.0
+	gopkg.in/yaml.v2 v2.4.0
+)
 
 require (
 	github.com/beorn7/perks v1.0.1 // indirect
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	HEAP_INFO_COMMAND     = "GC.heap_info"
+	HEAP_INFO_METRICS_SET = "heap_info"
+)
+
+var (
+	heapGenRE       = regexp.MustCompile(`^\s*([a-zA-Z][a-zA-Z -]*?)\s+total\s+(?:reserved (\d+[KMG]), committed )?(\d+[KMG]), used (\d+[KMG])`)
+	heapSpaceRE     = regexp.MustCompile(`^\s*(eden|from|to)\s+space (\d+[KMG]),\s+(\d+)% used`)
+	heapRegionsRE   = regexp.MustCompile(`^\s*region size (\d+[KMG]), (\d+) young \((\d+[KMG])\), (\d+) survivors \((\d+[KMG])\)`)
+	heapMetaspaceRE = regexp.MustCompile(`^\s*(Metaspace|class space)\s+used (\d+[KMG]),(?: capacity (\d+[KMG]),)? committed (\d+[KMG]), reserved (\d+[KMG])`)
+	heapZRE         = regexp.MustCompile(`^\s*ZHeap\s+used (\d+[KMG]), capacity (\d+[KMG]), max capacity (\d+[KMG])`)
+	heapShenRE      = regexp.MustCompile(`^\s*(\d+[KMG]) max, (\d+[KMG]) soft max, (\d+[KMG]) committed, (\d+[KMG]) used`)
+	heapShenRegRE   = regexp.MustCompile(`^\s*(\d+) x (\d+[KMG]) regions`)
+	heapEpsilonRE   = regexp.MustCompile(`^\s*- space (\d+[KMG]),\s+(\d+)% used`)
+)
+
+// collector and space label of the generations printed by GC.heap_info
+var heapGenerations = map[string][2]string{
+	"garbage-first heap":               {"G1", "heap"},
+	"PSYoungGen":                       {"Parallel", "young"},
+	"ParOldGen":                        {"Parallel", "old"},
+	"PSOldGen":                         {"Parallel", "old"},
+	"def new generation":               {"Serial", "young"},
+	"tenured generation":               {"Serial", "old"},
+	"par new generation":               {"CMS", "young"},
+	"concurrent mark-sweep generation": {"CMS", "old"},
+}
+
+func init() {
+	RegisterParser(HEAP_INFO_COMMAND, newHeapInfoParser)
+}
+
+// heapInfoParser parses GC.heap_info of the G1, Parallel, Serial, CMS, Z,
+// Shenandoah and Epsilon collectors into sizes per space, e.g. eden or
+// metaspace, and the collector in use.
+type heapInfoParser struct{}
+
+func newHeapInfoParser(task *JcmdTask) Parser {
+	return &heapInfoParser{}
+}
+
+func (p *heapInfoParser) Groups() []string {
+
+	return []string{"capacity", "used", "committed", "reserved", "max", "regions", "region_size", "collector"}
+}
+
+func (p *heapInfoParser) Labels(group string) []string {
+
+	switch group {
+	case "region_size":
+		return nil
+	case "collector":
+		return []string{"collector"}
+	}
+
+	return []string{"space"}
+}
+
+func (p *heapInfoParser) Parse(output string) ([]Sample, error) {
+
+	samples := make([]Sample, 0)
+	collector := ""
+
+	add := func(name string, space string, value string) {
+		samples = append(samples, Sample{Name: name, Value: value, Labels: map[string]string{"space": space}})
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+
+		line = strings.TrimRight(line, " \r")
+
+		switch strings.TrimSpace(line) {
+		case "Shenandoah Heap":
+			collector = "Shenandoah"
+			continue
+		case "Epsilon Heap":
+			collector = "Epsilon"
+			continue
+		}
+
+		if m := heapMetaspaceRE.FindStringSubmatch(line); m != nil {
+			space := "metaspace"
+			if m[1] != "Metaspace" {
+				space = "class_space"
+			}
+			add("used", space, heapSize(m[2]))
+			if m[3] != "" {
+				add("capacity", space, heapSize(m[3]))
+			}
+			add("committed", space, heapSize(m[4]))
+			add("reserved", space, heapSize(m[5]))
+			continue
+		}
+
+		if m := heapGenRE.FindStringSubmatch(line); m != nil {
+			gen, ok := heapGenerations[m[1]]
+			if !ok {
+				continue
+			}
+			if collector == "" {
+				collector = gen[0]
+			}
+			if m[2] != "" {
+				add("reserved", gen[1], heapSize(m[2]))
+			}
+			add("capacity", gen[1], heapSize(m[3]))
+			add("used", gen[1], heapSize(m[4]))
+			continue
+		}
+
+		if m := heapSpaceRE.FindStringSubmatch(line); m != nil {
+			add("capacity", m[1], heapSize(m[2]))
+			add("used", m[1], heapPercentOf(m[2], m[3]))
+			continue
+		}
+
+		if m := heapRegionsRE.FindStringSubmatch(line); m != nil {
+			samples = append(samples, Sample{Name: "region_size", Value: heapSize(m[1])})
+			add("regions", "young", m[2])
+			add("used", "young", heapSize(m[3]))
+			add("regions", "survivor", m[4])
+			add("used", "survivor", heapSize(m[5]))
+			continue
+		}
+
+		if m := heapZRE.FindStringSubmatch(line); m != nil {
+			collector = "Z"
+			add("used", "heap", heapSize(m[1]))
+			add("capacity", "heap", heapSize(m[2]))
+			add("max", "heap", heapSize(m[3]))
+			continue
+		}
+
+		if collector == "Shenandoah" {
+			if m := heapShenRE.FindStringSubmatch(line); m != nil {
+				add("max", "heap", heapSize(m[1]))
+				add("committed", "heap", heapSize(m[3]))
+				add("used", "heap", heapSize(m[4]))
+				continue
+			}
+			if m := heapShenRegRE.FindStringSubmatch(line); m != nil {
+				add("regions", "heap", m[1])
+				samples = append(samples, Sample{Name: "region_size", Value: heapSize(m[2])})
+				continue
+			}
+		}
+
+		if collector == "Epsilon" {
+			if m := heapEpsilonRE.FindStringSubmatch(line); m != nil {
+				add("capacity", "heap", heapSize(m[1]))
+				add("used", "heap", heapPercentOf(m[1], m[2]))
+			}
+		}
+	}
+
+	if collector == "" {
+		return nil, fmt.Errorf("unknown GC.heap_info output")
+	}
+
+	samples = append(samples, Sample{Name: "collector", Value: "1", Labels: map[string]string{"collector": collector}})
+
+	return samples, nil
+}
+
+// heapSize turns the sizes of GC.heap_info like "1024K" into ones
+// ParseSize reads.
+func heapSize(s string) string {
+
+	return s + "B"
+}
+
+// heapPercentOf returns percent of size in bytes. Spaces printed with their
+// usage in percent only have their used bytes estimated from it.
+func heapPercentOf(size string, percent string) string {
+
+	v, _ := ParseSize(heapSize(size), "B")
+
+	return fmt.Sprintf("%.0f", v*float64(atoi64(percent))/100)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHeapInfoParser(t *testing.T) {
+
+	tests := []struct {
+		file  string
+		cases []sampleCase
+	}{
+		{"heap_info/g1_jdk8.txt", []sampleCase{
+			{"collector", "collector", "G1", "1"},
+			{"capacity", "space", "heap", "262144KB"},
+			{"used", "space", "heap", "3072KB"},
+			{"region_size", "", "", "1024KB"},
+			{"regions", "space", "young", "4"},
+			{"capacity", "space", "metaspace", "4496KB"},
+			{"used", "space", "class_space", "353KB"},
+		}},
+		{"heap_info/g1_jdk17.txt", []sampleCase{
+			{"collector", "collector", "G1", "1"},
+			{"capacity", "space", "heap", "262144KB"},
+			{"used", "space", "heap", "21504KB"},
+			{"regions", "space", "young", "18"},
+			{"used", "space", "young", "18432KB"},
+			{"regions", "space", "survivor", "2"},
+			{"reserved", "space", "metaspace", "1114112KB"},
+			{"committed", "space", "class_space", "704KB"},
+		}},
+		{"heap_info/g1_jdk22.txt", []sampleCase{
+			{"collector", "collector", "G1", "1"},
+			{"reserved", "space", "heap", "4194304KB"},
+			{"region_size", "", "", "2048KB"},
+			{"regions", "space", "young", "9"},
+		}},
+		{"heap_info/parallel_jdk17.txt", []sampleCase{
+			{"collector", "collector", "Parallel", "1"},
+			{"capacity", "space", "young", "76288KB"},
+			{"used", "space", "young", "3932KB"},
+			{"capacity", "space", "eden", "65536KB"},
+			// 6% of eden
+			{"used", "space", "eden", "4026532"},
+			{"used", "space", "from", "0"},
+			{"capacity", "space", "old", "175104KB"},
+		}},
+		{"heap_info/serial_jdk17.txt", []sampleCase{
+			{"collector", "collector", "Serial", "1"},
+			{"capacity", "space", "young", "78656KB"},
+			{"used", "space", "eden", "4297851"},
+			{"capacity", "space", "old", "174784KB"},
+		}},
+		{"heap_info/cms_jdk8.txt", []sampleCase{
+			{"collector", "collector", "CMS", "1"},
+			{"used", "space", "young", "8392KB"},
+			{"used", "space", "eden", "8595702"},
+			{"capacity", "space", "old", "174784KB"},
+			{"committed", "space", "metaspace", "4864KB"},
+		}},
+		{"heap_info/shenandoah_jdk17.txt", []sampleCase{
+			{"collector", "collector", "Shenandoah", "1"},
+			{"max", "space", "heap", "4194304KB"},
+			{"committed", "space", "heap", "262144KB"},
+			{"used", "space", "heap", "29696KB"},
+			{"regions", "space", "heap", "2048"},
+			{"region_size", "", "", "2048KB"},
+		}},
+		{"heap_info/z_jdk17.txt", []sampleCase{
+			{"collector", "collector", "Z", "1"},
+			{"used", "space", "heap", "8MB"},
+			{"capacity", "space", "heap", "256MB"},
+			{"max", "space", "heap", "4096MB"},
+		}},
+		{"heap_info/epsilon_jdk17.txt", []sampleCase{
+			{"collector", "collector", "Epsilon", "1"},
+			{"capacity", "space", "heap", "4194304KB"},
+			{"used", "space", "heap", "0"},
+			{"used", "space", "metaspace", "842KB"},
+		}},
+	}
+
+	for _, tt := range tests {
+
+		samples, err := newHeapInfoParser(&JcmdTask{}).Parse(readExample(t, tt.file))
+		if err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+			continue
+		}
+
+		t.Run(tt.file, func(t *testing.T) {
+			checkSamples(t, samples, tt.cases)
+		})
+	}
+}
@@ -5,42 +5,20 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
-	"regexp"
 	"syscall"
+	"time"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var optBindAddr = flag.String("listen-address", ":2112", "The address to listen on for HTTP requests.")
-var optMainClass = flag.String("main-class", "SingleThread", "The main class of Java application.")
-
-func parse_response(s string, p *regexp.Regexp, m *metricsMap) {
-
-	if matches := p.FindStringSubmatch(s); matches != nil {
-
-		var v string
-		var fv float64
-		var err error
-
-		for group_name, metric := range *m {
-
-			v = matches[p.SubexpIndex(group_name)]
-
-			if fv, err = metric.ConvertFn(v); err != nil {
-				log.Printf("ERROR can not convert value '%s' of group '%s' - %v\n", v, group_name, err)
-				continue
-			}
-
-			(*metric.Gauge).Set(fv)
-		}
-
-	} else {
-		log.Println("\tERROR Regex not matched")
-	}
-}
+var optMainClass = flag.String("main-class", "SingleThread", "The main class of Java application. Used when no config file is given.")
+var optConfigFile = flag.String("config.file", "", "Path to the YAML or JSON config file with jcmd targets.")
+var optTimeoutOffset = flag.Duration("scrape.timeout-offset", 500*time.Millisecond, "Offset to subtract from the Prometheus scrape timeout for scrape mode targets.")
 
 func regestrySignalHandler(handlers map[os.Signal]signalHandler) {
 	c := make(chan os.Signal, 1)
@@ -75,9 +53,17 @@ func regestrySignalHandler(handlers map[os.Signal]signalHandler) {
 
 func main() {
 
+	// "jcmd-exporter validate [flags]" only checks the config
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		flag.CommandLine.Parse(os.Args[2:])
+		os.Exit(runValidate())
+	}
+
 	flag.Parse()
 
-	app := NewApplication(context.Background())
+	rand.Seed(time.Now().UnixNano())
+
+	app := NewApplication(context.Background(), *optConfigFile, *optMainClass)
 
 	regestrySignalHandler(map[os.Signal]signalHandler{
 		syscall.SIGINT:  app.terminate,
@@ -85,14 +71,19 @@ func main() {
 		syscall.SIGHUP:  app.reloadConfig,
 	})
 
-	_ = NewMetricsMap(ParseMetricDescJson([]byte(DEFAULT_METRICS_JSON)))
-	pattern := regexp.MustCompile(DEFAULT_REGEX_PATTERN)
+	if err := app.Reload(); err != nil {
+		log.Fatalf("ERROR %v\n", err)
+	}
+
+	app.RunDiscovery()
 
-	tasks := make([]*JcmdTask, 0)
-	RunTasks(app.ctx, pattern, tasks)
+	prometheus.MustRegister(&perfdataCollector{app: app})
+	prometheus.MustRegister(&nmtDetailCollector{app: app})
 
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/metrics", app.metricsHandler(*optTimeoutOffset))
+	mux.HandleFunc("/-/reload", app.reloadHandler)
+	mux.HandleFunc("/-/baseline", app.baselineHandler)
 
 	server_error := make(chan error, 1)
 	go func() {
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ProcessNs tells how to reach the files of a process that may live in
+// another PID and mount namespace, e.g. a JVM in a container seen from the
+// host. Files are reached through /proc/<pid>/root, JVM file names use the
+// pid inside the namespace.
+type ProcessNs struct {
+	Pid   int
+	NsPid int
+	Root  string
+	Uid   int
+	Gid   int
+}
+
+// GetProcessNs reads NSpid and the effective uid and gid from
+// /proc/<pid>/status. Root is empty when the process shares our mount
+// namespace root.
+func GetProcessNs(pid int) (*ProcessNs, error) {
+
+	proc := filepath.Join("/proc", strconv.Itoa(pid))
+
+	f, err := os.Open(filepath.Join(proc, "status"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ns := ProcessNs{Pid: pid, NsPid: pid, Uid: -1, Gid: -1}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "NSpid:":
+			// host pid first, the innermost namespace last
+			if v, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+				ns.NsPid = v
+			}
+		case "Uid:":
+			if len(fields) > 2 {
+				ns.Uid, _ = strconv.Atoi(fields[2])
+			}
+		case "Gid:":
+			if len(fields) > 2 {
+				ns.Gid, _ = strconv.Atoi(fields[2])
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if !sameFile(filepath.Join(proc, "root"), "/proc/self/root") {
+		ns.Root = filepath.Join(proc, "root")
+	}
+
+	return &ns, nil
+}
+
+// Path returns path as seen from inside the namespace of the process.
+func (ns *ProcessNs) Path(path string) string {
+
+	if ns.Root == "" {
+		return path
+	}
+
+	return filepath.Join(ns.Root, path)
+}
+
+// Foreign tells whether the process lives in another namespace.
+func (ns *ProcessNs) Foreign() bool {
+	return ns.Root != "" || ns.NsPid != ns.Pid
+}
+
+// RunAs tells whether files and connections of the process have to be made
+// as its user: we are root and the process is not. A root JVM accepts
+// whatever root creates.
+func (ns *ProcessNs) RunAs() bool {
+	return os.Geteuid() == 0 && ns.Uid > 0
+}
+
+func sameFile(a, b string) bool {
+
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(fa, fb)
+}
+
+// hostNsPids lists processes living in another namespace than ours.
+func hostNsPids() []*ProcessNs {
+
+	dirs, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		return nil
+	}
+
+	result := make([]*ProcessNs, 0)
+
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(filepath.Base(dir))
+		if err != nil {
+			continue
+		}
+
+		ns, err := GetProcessNs(pid)
+		if err != nil || !ns.Foreign() {
+			continue
+		}
+
+		result = append(result, ns)
+	}
+
+	return result
+}
+
+// dialAs connects to a unix socket with the given credentials. HotSpot
+// checks the peer credentials of attach connections, and older JDKs do not
+// accept root. Credentials are switched for the current thread only, with
+// a raw syscall, and that thread is thrown away afterwards.
+func dialAs(ctx context.Context, socket string, uid int, gid int) (net.Conn, error) {
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+
+	ch := make(chan result, 1)
+
+	go func() {
+		// never unlocked: the thread exits together with the goroutine
+		runtime.LockOSThread()
+
+		if err := setThreadCredentials(uid, gid); err != nil {
+			ch <- result{nil, err}
+			return
+		}
+
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "unix", socket)
+		ch <- result{conn, err}
+	}()
+
+	r := <-ch
+
+	return r.conn, r.err
+}
+
+// setThreadCredentials switches the current thread to uid and gid without
+// supplementary groups, so that no group of root is kept. Go's own
+// syscall.Set* apply to all threads, raw syscalls are used instead.
+func setThreadCredentials(uid int, gid int) error {
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SETGROUPS, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("setgroups - %v", errno)
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SETRESGID, uintptr(gid), uintptr(gid), uintptr(gid)); errno != 0 {
+		return fmt.Errorf("setresgid(%d) - %v", gid, errno)
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SETRESUID, uintptr(uid), uintptr(uid), uintptr(uid)); errno != 0 {
+		return fmt.Errorf("setresuid(%d) - %v", uid, errno)
+	}
+
+	return nil
+}
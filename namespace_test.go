@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestGetProcessNs(t *testing.T) {
+
+	ns, err := GetProcessNs(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ns.Pid != os.Getpid() || ns.NsPid != os.Getpid() || ns.Root != "" || ns.Foreign() {
+		t.Errorf("own process %+v is foreign", ns)
+	}
+
+	if ns.Uid != os.Geteuid() || ns.Gid != os.Getegid() {
+		t.Errorf("uid %d, gid %d, want %d, %d", ns.Uid, ns.Gid, os.Geteuid(), os.Getegid())
+	}
+
+	if _, err := GetProcessNs(deadPid); err == nil {
+		t.Error("no error for a process which is gone")
+	}
+}
+
+func TestProcessNsRunAs(t *testing.T) {
+
+	tests := []struct {
+		uid  int
+		want bool
+	}{
+		{1000, os.Geteuid() == 0},
+		// a root JVM and an unknown uid need no switch
+		{0, false},
+		{-1, false},
+	}
+
+	for _, tt := range tests {
+		ns := ProcessNs{Uid: tt.uid, Gid: tt.uid}
+		if got := ns.RunAs(); got != tt.want {
+			t.Errorf("uid %d: run as %v, want %v", tt.uid, got, tt.want)
+		}
+	}
+}
+
+// threadStatus returns the Uid, Gid and Groups lines of the status of the
+// current thread.
+func threadStatus(t *testing.T) map[string]string {
+
+	data, err := ioutil.ReadFile("/proc/thread-self/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) == 2 && (fields[0] == "Uid" || fields[0] == "Gid" || fields[0] == "Groups") {
+			status[fields[0]] = strings.Join(strings.Fields(fields[1]), " ")
+		}
+	}
+
+	return status
+}
+
+func TestSetThreadCredentials(t *testing.T) {
+
+	if os.Geteuid() != 0 {
+		t.Skip("needs root")
+	}
+
+	type result struct {
+		status map[string]string
+		err    error
+	}
+	ch := make(chan result, 1)
+
+	go func() {
+		// never unlocked: the thread exits together with the goroutine
+		runtime.LockOSThread()
+		err := setThreadCredentials(65534, 65534)
+		ch <- result{threadStatus(t), err}
+	}()
+
+	r := <-ch
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+
+	want := map[string]string{"Uid": "65534 65534 65534 65534", "Gid": "65534 65534 65534 65534", "Groups": ""}
+	for name, value := range want {
+		if r.status[name] != value {
+			t.Errorf("%s %q, want %q", name, r.status[name], value)
+		}
+	}
+
+	// the other threads keep root
+	if os.Geteuid() != 0 {
+		t.Errorf("process euid %d, want 0", os.Geteuid())
+	}
+}
+
+func TestDialAs(t *testing.T) {
+
+	if os.Geteuid() != 0 {
+		t.Skip("needs root")
+	}
+
+	// t.TempDir is not reachable by other users
+	dir, err := ioutil.TempDir("", "dialas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Chmod(dir, 0755)
+
+	socket := filepath.Join(dir, "attach")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	os.Chmod(socket, 0777)
+
+	creds := make(chan *syscall.Ucred, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			creds <- nil
+			return
+		}
+		defer conn.Close()
+
+		raw, _ := conn.(*net.UnixConn).SyscallConn()
+		var cred *syscall.Ucred
+		raw.Control(func(fd uintptr) {
+			cred, _ = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+		})
+		creds <- cred
+	}()
+
+	conn, err := dialAs(context.Background(), socket, 65534, 65534)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// HotSpot checks the peer credentials against its own
+	if cred := <-creds; cred == nil || cred.Uid != 65534 || cred.Gid != 65534 {
+		t.Errorf("peer credentials %+v, want uid and gid 65534", cred)
+	}
+}
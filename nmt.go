@@ -0,0 +1,300 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+const NMT_COMMAND = "VM.native_memory"
+
+// NMT output dialects, named after the first JDK printing them
+const (
+	NMT_DIALECT_JDK8  = 8  // no instance and array class counts
+	NMT_DIALECT_JDK11 = 11 // instance and array class counts
+	NMT_DIALECT_JDK17 = 17 // a Metaspace category of its own
+	NMT_DIALECT_JDK21 = 21 // malloc and mmap totals, peaks, "threads #"
+)
+
+var (
+	nmtTotalRE     = regexp.MustCompile(`^Total:\s+(.*)$`)
+	nmtTotalLineRE = regexp.MustCompile(`^\s+([a-z]+:\s+.*)$`)
+	nmtCategoryRE  = regexp.MustCompile(`^-\s*(.+?)\s+\((.*)\)\s*$`)
+	nmtLineRE      = regexp.MustCompile(`^\s+(\(.*\))\s*$`)
+	nmtGroupRE     = regexp.MustCompile(`\(([^()]*)\)`)
+	nmtSizeRE      = regexp.MustCompile(`^(?:([a-zA-Z][a-zA-Z ]*?)\s*=\s*)?(\d+[KMG]?B)(?:\s+=[\d.]+%)?(?:\s+([+-]\d+[KMG]?B))?(?:\s+tag=[^#]*)?(?:\s*#(\d+)(?:\s+([+-]\d+))?)?`)
+	nmtCountRE     = regexp.MustCompile(`^([a-zA-Z][a-zA-Z ]*?)\s*#(\d+)(?:\s+([+-]\d+))?`)
+	nmtSlugRE      = regexp.MustCompile(`[^a-z0-9]+`)
+	nmtMetaspaceRE = regexp.MustCompile(`(?m)^-\s+Metaspace\s+\(`)
+	nmtMallocRE    = regexp.MustCompile(`(?m)^\s+malloc:\s+\d+[KMG]?B`)
+)
+
+// nmt value names which differ from what the generic naming would give
+var nmtNameAliases = map[string]string{
+	"tracking_overhead": "overhead",
+}
+
+// value names of a dialect which are renamed to the ones of older JDKs, so
+// that the series stay the same across JDK upgrades
+var nmtDialectAliases = map[int]map[string]string{
+	NMT_DIALECT_JDK21: {
+		"thread_threads_total": "thread_total",
+	},
+}
+
+// NmtDialect guesses from the shape of "VM.native_memory summary" output
+// which JDK printed it.
+func NmtDialect(s string) int {
+
+	switch {
+	case strings.Contains(s, "(peak=") || strings.Contains(s, "at peak") ||
+		strings.Contains(s, "(threads #") || nmtMallocRE.MatchString(s):
+		return NMT_DIALECT_JDK21
+	case nmtMetaspaceRE.MatchString(s):
+		return NMT_DIALECT_JDK17
+	case strings.Contains(s, "instance classes #"):
+		return NMT_DIALECT_JDK11
+	}
+
+	return NMT_DIALECT_JDK8
+}
+
+// ParseNmtSummary splits "VM.native_memory summary" output into categories
+// and their lines and returns every value found, keyed by metric name:
+// <category>[_<sub block>][_<prefix>]_<name>_bytes for sizes, with their
+// unit as printed for any scale= (see ParseSize), and
+// <category>[_<sub block>]_<name>_total for counts. For example
+// "- Class ... (mmap: reserved=1KB" gives class_mmap_reserved_bytes and
+// "(  Class space:)" followed by "(    used=8KB)" gives
+// class_class_space_used_bytes. Missing or unknown categories do not affect
+// the others. The output of JDK 8 up to the latest is understood, see
+// NmtDialect. Peaks are named after the value they belong to, e.g.
+// "(malloc=1KB #2) (peak=3KB #4)" gives <category>_malloc_peak_bytes and
+// "(mmap: reserved=1KB, committed=1KB, at peak)" gives
+// <category>_mmap_peak_bytes with the committed size.
+func ParseNmtSummary(s string) map[string]string {
+
+	values := make(map[string]string)
+
+	category, sub := "", ""
+
+	for _, line := range strings.Split(s, "\n") {
+
+		line = strings.TrimRight(line, " \r")
+
+		if m := nmtTotalRE.FindStringSubmatch(line); m != nil {
+			category, sub = "total", ""
+			nmtParseItems(values, category, m[1], &nmtPeakOwner{})
+			continue
+		}
+
+		if m := nmtCategoryRE.FindStringSubmatch(line); m != nil {
+			category, sub = nmtSlug(m[1]), ""
+			nmtParseItems(values, category, m[2], &nmtPeakOwner{})
+			continue
+		}
+
+		if category == "" {
+			continue
+		}
+
+		// "malloc: 1KB #2" and "mmap: reserved=..." below Total since JDK 21
+		if category == "total" {
+			if m := nmtTotalLineRE.FindStringSubmatch(line); m != nil {
+				nmtParseItems(values, category, m[1], &nmtPeakOwner{})
+				continue
+			}
+		}
+
+		m := nmtLineRE.FindStringSubmatch(line)
+		if m == nil {
+			if strings.TrimSpace(line) != "" {
+				category, sub = "", ""
+			}
+			continue
+		}
+
+		// since JDK 21 a line may hold several groups like
+		// "(malloc=1KB #2) (peak=1KB #2)"
+		var last nmtPeakOwner
+
+		for _, group := range nmtGroupRE.FindAllStringSubmatch(m[1], -1) {
+
+			inner := strings.TrimSpace(group[1])
+
+			// a sub block header like "Metadata:" or "Class space:"
+			if strings.HasSuffix(inner, ":") && !strings.ContainsAny(inner, "=#") {
+				sub = nmtSlug(strings.TrimSuffix(inner, ":"))
+				continue
+			}
+
+			base := category
+			if sub != "" {
+				base += "_" + sub
+			}
+
+			nmtParseItems(values, base, inner, &last)
+		}
+	}
+
+	if aliases, ok := nmtDialectAliases[NmtDialect(s)]; ok {
+		for name, alias := range aliases {
+			for _, pair := range [][2]string{{name, alias}, {nmtDeltaName(name), nmtDeltaName(alias)}} {
+				if v, ok := values[pair[0]]; ok {
+					values[pair[1]] = v
+					delete(values, pair[0])
+				}
+			}
+		}
+	}
+
+	return values
+}
+
+// nmtPeakOwner is the value a following "peak=" or "at peak" refers to.
+type nmtPeakOwner struct {
+	name  string
+	value string
+}
+
+// nmtParseItems parses a comma separated list like
+// "mmap: reserved=1KB, committed=1KB", "malloc=110KB #570" or
+// "malloc: 110KB #570, peak=120KB #600". A "prefix:" applies to the
+// following items of the list. last is updated with the value peaks refer
+// to: the prefix, or the last named size when there is none.
+func nmtParseItems(values map[string]string, base string, s string, last *nmtPeakOwner) {
+
+	prefix := ""
+
+	for _, item := range strings.Split(s, ",") {
+
+		item = strings.TrimSpace(item)
+
+		if i := strings.Index(item, ":"); i >= 0 && !strings.ContainsAny(item[:i], "=#") {
+			prefix = nmtSlug(item[:i])
+			item = strings.TrimSpace(item[i+1:])
+		}
+
+		if item == "at peak" {
+			if last.name != "" {
+				values[last.name+"_peak_bytes"] = last.value
+			}
+			continue
+		}
+
+		name := base
+		if prefix != "" {
+			name += "_" + prefix
+		}
+
+		if m := nmtSizeRE.FindStringSubmatch(item); m != nil {
+
+			if nmtSlug(m[1]) == "peak" {
+				if last.name != "" {
+					values[last.name+"_peak_bytes"] = m[2]
+				}
+				continue
+			}
+
+			// "malloc: 1KB" has no name of its own
+			if m[1] != "" {
+				name = nmtName(name, m[1])
+			}
+			nmtSet(values, name+"_bytes", m[2], m[3])
+			if m[4] != "" {
+				nmtSet(values, name+"_total", m[4], m[5])
+			}
+
+			if prefix != "" {
+				*last = nmtPeakOwner{base + "_" + prefix, m[2]}
+			} else {
+				*last = nmtPeakOwner{name, m[2]}
+			}
+			continue
+		}
+
+		if m := nmtCountRE.FindStringSubmatch(item); m != nil {
+			// "thread #18" in the Thread category is thread_total
+			if nmtSlug(m[1]) == base {
+				nmtSet(values, base+"_total", m[2], m[3])
+			} else {
+				nmtSet(values, nmtName(name, m[1])+"_total", m[2], m[3])
+			}
+		}
+	}
+}
+
+// nmtSet stores a value and, in summary.diff output, its change since the
+// baseline.
+func nmtSet(values map[string]string, name string, value string, delta string) {
+
+	values[name] = value
+	if delta != "" {
+		values[nmtDeltaName(name)] = delta
+	}
+}
+
+// nmtDeltaName returns the name of the change of a value since the
+// baseline: class_reserved_bytes -> class_reserved_delta_bytes.
+func nmtDeltaName(name string) string {
+
+	for _, suffix := range []string{"_bytes", "_total"} {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix) + "_delta" + suffix
+		}
+	}
+
+	return name + "_delta"
+}
+
+func nmtName(base string, name string) string {
+
+	slug := nmtSlug(name)
+	if alias, ok := nmtNameAliases[slug]; ok {
+		slug = alias
+	}
+
+	return base + "_" + slug
+}
+
+func nmtSlug(s string) string {
+
+	return strings.Trim(nmtSlugRE.ReplaceAllString(strings.ToLower(s), "_"), "_")
+}
+
+func init() {
+	RegisterParser(NMT_COMMAND, newNmtParser)
+}
+
+// nmtParser parses summary, summary.diff for baseline tasks and detail,
+// which also keeps the callsites in the task.
+type nmtParser struct {
+	task *JcmdTask
+}
+
+func newNmtParser(task *JcmdTask) Parser {
+	return &nmtParser{task: task}
+}
+
+func (p *nmtParser) Parse(output string) ([]Sample, error) {
+
+	values := ParseNmtSummary(output)
+
+	if p.task.Baseline {
+		var err error
+		if values, err = ParseNmtSummaryDiff(output); err != nil {
+			p.task.resetBaseline()
+			return nil, err
+		}
+	}
+
+	if p.task.IsNmtDetail() {
+		p.task.setDetail(ParseNmtDetail(output, p.task.FrameDepth, p.task.TopN))
+	}
+
+	samples := make([]Sample, 0, len(values))
+	for name, value := range values {
+		samples = append(samples, Sample{Name: name, Value: value})
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	NMT_BASELINE     = "baseline"
+	NMT_SUMMARY      = "summary"
+	NMT_SUMMARY_DIFF = "summary.diff"
+)
+
+var nmtBaselineTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "jcmd_exporter",
+	Name:      "nmt_baseline_timestamp_seconds",
+	Help:      "Time of the last NMT baseline the deltas of a task refer to.",
+}, []string{"task"})
+
+// ParseNmtSummaryDiff parses "VM.native_memory summary.diff" output like
+// ParseNmtSummary. Values unchanged since the baseline are printed without
+// a delta, they get a delta of 0.
+func ParseNmtSummaryDiff(s string) (map[string]string, error) {
+
+	if strings.Contains(s, "baseline for comparison") {
+		return nil, fmt.Errorf("no NMT baseline")
+	}
+
+	values := ParseNmtSummary(s)
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+
+	for _, name := range names {
+		if strings.Contains(name, "_delta_") || strings.Contains(name, "_peak_") {
+			continue
+		}
+		if _, ok := values[nmtDeltaName(name)]; !ok {
+			values[nmtDeltaName(name)] = "0"
+		}
+	}
+
+	return values, nil
+}
+
+// withNmtDeltas adds to a metric set the delta of each of its values, as
+// printed by summary.diff. Peaks have no delta.
+func withNmtDeltas(attrs []MetricDescAttr) []MetricDescAttr {
+
+	result := make([]MetricDescAttr, 0, 2*len(attrs))
+	result = append(result, attrs...)
+
+	for _, attr := range attrs {
+		if strings.Contains(attr.Name, "_peak_") {
+			continue
+		}
+
+		result = append(result, MetricDescAttr{
+			ReGroup: attr.ReGroup + "_delta",
+			Name:    nmtDeltaName(attr.Name),
+			Help:    attr.Help + " Delta since Baseline",
+			Convert: attr.Convert,
+		})
+	}
+
+	return result
+}
+
+// ensureBaseline takes the NMT baseline of the task when there is none yet
+// or when it is older than BaselineIntervalMs.
+func (t *JcmdTask) ensureBaseline(ctx context.Context, timeout time.Duration) error {
+
+	t.baselineMu.Lock()
+	at := t.baselineAt
+	t.baselineMu.Unlock()
+
+	interval := time.Duration(t.BaselineIntervalMs) * time.Millisecond
+
+	if !at.IsZero() && (interval == 0 || time.Since(at) < interval) {
+		return nil
+	}
+
+	return t.TakeBaseline(ctx, timeout)
+}
+
+// TakeBaseline runs "VM.native_memory baseline", the following summary.diff
+// collections report the changes since now.
+func (t *JcmdTask) TakeBaseline(ctx context.Context, timeout time.Duration) error {
+
+	t.baselineMu.Lock()
+	defer t.baselineMu.Unlock()
+
+	output, err := t.Executor.Execute(ctx, timeout, t.Target(), []string{NMT_COMMAND, NMT_BASELINE})
+	if err != nil {
+		return fmt.Errorf("baseline - %v", err)
+	}
+
+	if !strings.Contains(output, "succeeded") {
+		return fmt.Errorf("baseline - %s", strings.TrimSpace(output))
+	}
+
+	t.baselineAt = time.Now()
+	nmtBaselineTimestamp.WithLabelValues(t.Name).Set(float64(t.baselineAt.Unix()))
+
+	log.Printf("INFO task %s NMT baseline taken\n", t.Name)
+
+	return nil
+}
+
+// resetBaseline forgets the baseline, e.g. when the JVM was restarted.
+func (t *JcmdTask) resetBaseline() {
+
+	t.baselineMu.Lock()
+	t.baselineAt = time.Time{}
+	t.baselineMu.Unlock()
+}
+
+// baselineHandler takes a new NMT baseline for all baseline tasks, or only
+// for the one given by the "task" parameter.
+func (a *Application) baselineHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "This endpoint requires a POST request.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.URL.Query().Get("task")
+
+	a.mu.Lock()
+	tasks := make([]*JcmdTask, 0)
+	for _, rt := range a.tasks {
+		if rt.task.Baseline && (name == "" || rt.task.Name == name) {
+			tasks = append(tasks, rt.task)
+		}
+	}
+	a.mu.Unlock()
+
+	if len(tasks) == 0 {
+		http.Error(w, "no matching baseline task", http.StatusNotFound)
+		return
+	}
+
+	var b strings.Builder
+	failed := false
+
+	for _, task := range tasks {
+		if err := task.TakeBaseline(r.Context(), time.Duration(task.TimeoutMs)*time.Millisecond); err != nil {
+			log.Printf("ERROR task %s - %v\n", task.Name, err)
+			fmt.Fprintf(&b, "%s: %v\n", task.Name, err)
+			failed = true
+			continue
+		}
+		fmt.Fprintf(&b, "%s: OK\n", task.Name)
+	}
+
+	if failed {
+		http.Error(w, b.String(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, b.String())
+}
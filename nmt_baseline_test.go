@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseNmtSummaryDiff(t *testing.T) {
+
+	values, err := ParseNmtSummaryDiff(readExample(t, "nmt/jdk17_summary_diff.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"total_reserved_bytes", "5718502KB"},
+		{"total_reserved_delta_bytes", "+1063KB"},
+		{"total_committed_delta_bytes", "+1319KB"},
+		{"thread_total", "21"},
+		{"thread_delta_total", "+2"},
+		{"thread_stack_reserved_delta_bytes", "+2048KB"},
+		{"thread_malloc_delta_total", "+12"},
+		{"class_class_space_waste_delta_bytes", "-9KB"},
+		{"class_metadata_waste_delta_bytes", "+25KB"},
+		// unchanged values are printed without a delta
+		{"java_heap_reserved_bytes", "4063232KB"},
+		{"java_heap_reserved_delta_bytes", "0"},
+		{"compiler_malloc_delta_total", "0"},
+	}
+
+	for _, tt := range tests {
+		if got, ok := values[tt.name]; !ok || got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := ParseNmtSummaryDiff("12345:\nNo detail baseline for comparison\n"); err == nil {
+		t.Error("no error without a baseline")
+	}
+}
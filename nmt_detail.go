@@ -0,0 +1,358 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	NMT_DETAIL = "detail"
+
+	DEFAULT_TOP_N       = 10
+	DEFAULT_FRAME_DEPTH = 1
+
+	// callsite label of the sites beyond the top N
+	NMT_CALLSITE_OTHER = "other"
+)
+
+var (
+	nmtRegionRE   = regexp.MustCompile(`^\[0x[0-9a-f]+ - 0x[0-9a-f]+\] reserved (\d+[KMG]?B) for (.+?)(?: from)?$`)
+	nmtCommitRE   = regexp.MustCompile(`^\s+\[0x[0-9a-f]+ - 0x[0-9a-f]+\] committed (\d+[KMG]?B)`)
+	nmtFrameRE    = regexp.MustCompile(`^\s*\[0x[0-9a-f]+\]\s*(.+?)(?:\+0x[0-9a-f]+)?(?: in \S+)?$`)
+	nmtSiteRE     = regexp.MustCompile(`^\s+\(malloc=(\d+[KMG]?B)(?: (?:type|tag)=(.+?))?(?: #(\d+))?\)`)
+	nmtSiteMmapRE = regexp.MustCompile(`^\s+\(mmap: reserved=(\d+[KMG]?B), committed=(\d+[KMG]?B)(?:, peak=\d+[KMG]?B)?(?:\s+(?:Type|type|tag)=(.+?))?\)`)
+)
+
+// NmtCallsite is the memory in bytes allocated from one callsite, the top
+// frames joined by ";".
+type NmtCallsite struct {
+	Callsite  string
+	Category  string
+	Malloc    int64
+	Count     int64
+	Reserved  int64
+	Committed int64
+}
+
+// NmtRegions counts the regions of one category in the virtual memory map.
+type NmtRegions struct {
+	Reserved  int
+	Committed int
+}
+
+type NmtDetail struct {
+	Malloc  []NmtCallsite
+	Mmap    []NmtCallsite
+	Regions map[string]*NmtRegions
+}
+
+// ParseNmtDetail parses the virtual memory map and the callsites of
+// "VM.native_memory detail" output. Callsites are aggregated by their top
+// depth frames and category; only the topN biggest malloc and mmap
+// callsites are kept, the others are summed up per category as callsite
+// "other". The summary part is left to ParseNmtSummary.
+func ParseNmtDetail(s string, depth int, topN int) *NmtDetail {
+
+	detail := NmtDetail{Regions: make(map[string]*NmtRegions)}
+
+	malloc := make(map[[2]string]*NmtCallsite)
+	mmap := make(map[[2]string]*NmtCallsite)
+
+	var region *NmtRegions
+	frames := make([]string, 0, depth)
+	details := false
+
+	for _, line := range strings.Split(s, "\n") {
+
+		line = strings.TrimRight(line, " \r")
+
+		// the callsites follow the virtual memory map
+		if line == "Details:" {
+			details = true
+			continue
+		}
+
+		if !details {
+			if m := nmtRegionRE.FindStringSubmatch(line); m != nil {
+				region = detail.Regions[m[2]]
+				if region == nil {
+					region = &NmtRegions{}
+					detail.Regions[m[2]] = region
+				}
+				region.Reserved++
+			} else if nmtCommitRE.MatchString(line) && region != nil {
+				region.Committed++
+			}
+			continue
+		}
+
+		if m := nmtSiteRE.FindStringSubmatch(line); m != nil {
+			site := nmtSite(malloc, frames, m[2])
+			site.Malloc += nmtBytes(m[1])
+			site.Count += atoi64(m[3])
+			frames = frames[:0]
+			continue
+		}
+
+		if m := nmtSiteMmapRE.FindStringSubmatch(line); m != nil {
+			site := nmtSite(mmap, frames, m[3])
+			site.Reserved += nmtBytes(m[1])
+			site.Committed += nmtBytes(m[2])
+			frames = frames[:0]
+			continue
+		}
+
+		if m := nmtFrameRE.FindStringSubmatch(line); m != nil {
+			if len(frames) < depth {
+				frames = append(frames, nmtFrameName(m[1]))
+			}
+			continue
+		}
+
+		frames = frames[:0]
+	}
+
+	detail.Malloc = nmtTopSites(malloc, topN, func(c *NmtCallsite) int64 { return c.Malloc })
+	detail.Mmap = nmtTopSites(mmap, topN, func(c *NmtCallsite) int64 { return c.Committed })
+
+	return &detail
+}
+
+// nmtFrameName strips the argument list from a frame, overloads are
+// aggregated. Template arguments may hold parentheses too, so the list is
+// found from its end.
+func nmtFrameName(frame string) string {
+
+	end := strings.LastIndex(frame, ")")
+	if end < 0 {
+		return frame
+	}
+
+	depth := 0
+	for i := end; i > 0; i-- {
+		switch frame[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+			if depth == 0 {
+				return frame[:i]
+			}
+		}
+	}
+
+	return frame
+}
+
+func nmtSite(sites map[[2]string]*NmtCallsite, frames []string, category string) *NmtCallsite {
+
+	key := [2]string{strings.Join(frames, ";"), strings.TrimSpace(category)}
+
+	site, ok := sites[key]
+	if !ok {
+		site = &NmtCallsite{Callsite: key[0], Category: key[1]}
+		sites[key] = site
+	}
+
+	return site
+}
+
+func nmtTopSites(sites map[[2]string]*NmtCallsite, topN int, size func(*NmtCallsite) int64) []NmtCallsite {
+
+	all := make([]NmtCallsite, 0, len(sites))
+	for _, site := range sites {
+		all = append(all, *site)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if size(&all[i]) != size(&all[j]) {
+			return size(&all[i]) > size(&all[j])
+		}
+		return all[i].Callsite < all[j].Callsite
+	})
+
+	if len(all) <= topN {
+		return all
+	}
+
+	top := all[:topN]
+	others := make(map[string]*NmtCallsite)
+
+	for _, site := range all[topN:] {
+		other, ok := others[site.Category]
+		if !ok {
+			other = &NmtCallsite{Callsite: NMT_CALLSITE_OTHER, Category: site.Category}
+			others[site.Category] = other
+		}
+		other.Malloc += site.Malloc
+		other.Count += site.Count
+		other.Reserved += site.Reserved
+		other.Committed += site.Committed
+	}
+
+	for _, other := range others {
+		top = append(top, *other)
+	}
+
+	return top
+}
+
+func nmtBytes(s string) int64 {
+
+	v, _ := ParseSize(s, "B")
+
+	return int64(v)
+}
+
+func atoi64(s string) int64 {
+
+	v, _ := strconv.ParseInt(s, 10, 64)
+
+	return v
+}
+
+// IsNmtDetail tells whether the task runs "VM.native_memory detail".
+func (t *JcmdTask) IsNmtDetail() bool {
+
+	if t.SubSystem != NMT_COMMAND {
+		return false
+	}
+
+	for _, arg := range t.ExtraArgs {
+		if arg == NMT_DETAIL {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *JcmdTask) currentDetail() *NmtDetail {
+
+	t.detailMu.Lock()
+	defer t.detailMu.Unlock()
+
+	return t.detail
+}
+
+func (t *JcmdTask) setDetail(detail *NmtDetail) {
+
+	t.detailMu.Lock()
+	defer t.detailMu.Unlock()
+
+	t.detail = detail
+}
+
+// labels of the callsite metrics besides those of the task, the region
+// metrics only have the category
+var nmtDetailLabels = []string{"callsite", "category"}
+
+// nmtDetailCollector exports the callsites and regions of the last
+// collection of every NMT detail task. Callsites change between
+// collections, so it is an unchecked collector.
+type nmtDetailCollector struct {
+	app *Application
+}
+
+func (c *nmtDetailCollector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+func (c *nmtDetailCollector) Collect(ch chan<- prometheus.Metric) {
+
+	tasks := c.app.nmtDetailTasks()
+	if len(tasks) == 0 {
+		return
+	}
+
+	// tasks of different metric sets have different labels
+	taskLabels := make([]prometheus.Labels, len(tasks))
+	labelNames := make([]string, 0)
+	seen := make(map[string]bool)
+	for i, task := range tasks {
+		taskLabels[i] = task.currentLabels()
+		for name := range taskLabels[i] {
+			if !seen[name] {
+				seen[name] = true
+				labelNames = append(labelNames, name)
+			}
+		}
+	}
+	sort.Strings(labelNames)
+
+	siteLabels := append(append([]string{}, labelNames...), nmtDetailLabels...)
+	regionLabels := append(append([]string{}, labelNames...), "category")
+
+	mallocDesc := prometheus.NewDesc("jcmd_native_memory_callsite_malloc_bytes",
+		"Bytes malloced by the top callsites of jcmd VM.native_memory detail", siteLabels, nil)
+	mallocCountDesc := prometheus.NewDesc("jcmd_native_memory_callsite_malloc_count",
+		"Malloc count of the top callsites of jcmd VM.native_memory detail", siteLabels, nil)
+	reservedDesc := prometheus.NewDesc("jcmd_native_memory_callsite_mmap_reserved_bytes",
+		"Bytes reserved by the top mmap callsites of jcmd VM.native_memory detail", siteLabels, nil)
+	committedDesc := prometheus.NewDesc("jcmd_native_memory_callsite_mmap_committed_bytes",
+		"Bytes committed by the top mmap callsites of jcmd VM.native_memory detail", siteLabels, nil)
+	reservedRegionsDesc := prometheus.NewDesc("jcmd_native_memory_reserved_regions",
+		"Reserved regions of the virtual memory map of jcmd VM.native_memory detail", regionLabels, nil)
+	committedRegionsDesc := prometheus.NewDesc("jcmd_native_memory_committed_regions",
+		"Committed regions of the virtual memory map of jcmd VM.native_memory detail", regionLabels, nil)
+
+	for i, task := range tasks {
+
+		detail := task.currentDetail()
+		if detail == nil {
+			continue
+		}
+
+		labelValues := make([]string, len(labelNames))
+		for j, name := range labelNames {
+			labelValues[j] = taskLabels[i][name]
+		}
+
+		emit := func(desc *prometheus.Desc, value float64, extra ...string) {
+
+			m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, value, append(append([]string{}, labelValues...), extra...)...)
+			if err != nil {
+				log.Printf("ERROR task %s - %v\n", task.Name, err)
+				return
+			}
+
+			ch <- m
+		}
+
+		for _, site := range detail.Malloc {
+			emit(mallocDesc, float64(site.Malloc), site.Callsite, site.Category)
+			emit(mallocCountDesc, float64(site.Count), site.Callsite, site.Category)
+		}
+
+		for _, site := range detail.Mmap {
+			emit(reservedDesc, float64(site.Reserved), site.Callsite, site.Category)
+			emit(committedDesc, float64(site.Committed), site.Callsite, site.Category)
+		}
+
+		for category, regions := range detail.Regions {
+			emit(reservedRegionsDesc, float64(regions.Reserved), category)
+			emit(committedRegionsDesc, float64(regions.Committed), category)
+		}
+	}
+}
+
+// nmtDetailTasks returns the running NMT detail tasks.
+func (a *Application) nmtDetailTasks() []*JcmdTask {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tasks := make([]*JcmdTask, 0)
+
+	for _, rt := range a.tasks {
+		if rt.task.IsNmtDetail() {
+			tasks = append(tasks, rt.task)
+		}
+	}
+
+	return tasks
+}
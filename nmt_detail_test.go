@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseNmtDetail(t *testing.T) {
+
+	detail := ParseNmtDetail(readExample(t, "nmt/jdk17_detail.txt"), 1, 10)
+
+	mmap := []NmtCallsite{
+		{Callsite: "ReservedHeapSpace::try_reserve_heap", Category: "Java Heap", Reserved: 1040187392, Committed: 100663296},
+		{Callsite: "ReservedCodeSpace::ReservedCodeSpace", Category: "Code", Reserved: 253632512, Committed: 2555904},
+		{Callsite: "JavaThread::run", Category: "Thread Stack", Reserved: 2097152, Committed: 32768},
+	}
+
+	malloc := []NmtCallsite{
+		{Callsite: "MallocArrayAllocator<unsigned char>::allocate", Category: "GC", Malloc: 10485760, Count: 9},
+		{Callsite: "BitMap::reallocate", Category: "GC", Malloc: 6291456, Count: 2},
+		{Callsite: "Symbol::operator new", Category: "Symbol", Malloc: 919552, Count: 4109},
+		{Callsite: "os::malloc", Category: "Arena Chunk", Malloc: 180224, Count: 5},
+		{Callsite: "MallocSiteTable::new_entry", Category: "Native Memory Tracking", Malloc: 106496, Count: 1321},
+		{Callsite: "os::malloc", Category: "Class", Malloc: 98304, Count: 120},
+		{Callsite: "os::malloc", Category: "Internal", Malloc: 12288, Count: 24},
+	}
+
+	checkCallsites(t, "mmap", detail.Mmap, mmap)
+	checkCallsites(t, "malloc", detail.Malloc, malloc)
+
+	// the sites beyond the top N are summed up per category
+	top := ParseNmtDetail(readExample(t, "nmt/jdk17_detail.txt"), 1, 2)
+	if len(top.Malloc) != 2+5 {
+		t.Errorf("top 2: %d malloc callsites, want 2 and the others of 5 categories", len(top.Malloc))
+	}
+	for _, site := range top.Malloc[2:] {
+		if site.Callsite != NMT_CALLSITE_OTHER {
+			t.Errorf("top 2: callsite %q beyond the top", site.Callsite)
+		}
+	}
+}
+
+func checkCallsites(t *testing.T, kind string, got []NmtCallsite, want []NmtCallsite) {
+
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("%d %s callsites, want %d: %v", len(got), kind, len(want), got)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s callsite %d = %+v, want %+v", kind, i, got[i], want[i])
+		}
+	}
+}
+
+func TestNmtFrameName(t *testing.T) {
+
+	tests := []struct {
+		frame string
+		want  string
+	}{
+		{"os::malloc(unsigned long, MEMFLAGS, NativeCallStack const&)", "os::malloc"},
+		{"GrowableArray<int (*)(void*)>::grow(int)", "GrowableArray<int (*)(void*)>::grow"},
+		{"JavaThread::run", "JavaThread::run"},
+	}
+
+	for _, tt := range tests {
+		if got := nmtFrameName(tt.frame); got != tt.want {
+			t.Errorf("nmtFrameName(%q) = %q, want %q", tt.frame, got, tt.want)
+		}
+	}
+}
+
+func TestNmtDetailCollector(t *testing.T) {
+
+	task := &JcmdTask{
+		SubSystem: NMT_COMMAND,
+		ExtraArgs: []string{NMT_DETAIL},
+		labels:    prometheus.Labels{"pid": "1"},
+	}
+	task.setDetail(&NmtDetail{
+		Malloc: []NmtCallsite{{Callsite: "os::malloc", Category: "Class", Malloc: 98304, Count: 120}},
+	})
+
+	c := &nmtDetailCollector{app: &Application{tasks: map[string]*runningTask{"a": {task: task}}}}
+
+	// the count of live allocations goes up and down, it is not a counter
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP jcmd_native_memory_callsite_malloc_bytes Bytes malloced by the top callsites of jcmd VM.native_memory detail
+# TYPE jcmd_native_memory_callsite_malloc_bytes gauge
+jcmd_native_memory_callsite_malloc_bytes{callsite="os::malloc",category="Class",pid="1"} 98304
+# HELP jcmd_native_memory_callsite_malloc_count Malloc count of the top callsites of jcmd VM.native_memory detail
+# TYPE jcmd_native_memory_callsite_malloc_count gauge
+jcmd_native_memory_callsite_malloc_count{callsite="os::malloc",category="Class",pid="1"} 120
+`))
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// readExample returns the content of a file of examples/.
+func readExample(t *testing.T, name string) string {
+
+	t.Helper()
+
+	data, err := ioutil.ReadFile("examples/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestNmtDialect(t *testing.T) {
+
+	tests := []struct {
+		file string
+		want int
+	}{
+		{"nmt/jdk8_summary.txt", NMT_DIALECT_JDK8},
+		{"nmt/jdk11_summary.txt", NMT_DIALECT_JDK11},
+		{"nmt/jdk17_summary.txt", NMT_DIALECT_JDK17},
+		{"nmt/jdk21_summary.txt", NMT_DIALECT_JDK21},
+		{"nmt/jdk23_summary.txt", NMT_DIALECT_JDK21},
+	}
+
+	for _, tt := range tests {
+		if got := NmtDialect(readExample(t, tt.file)); got != tt.want {
+			t.Errorf("%s: dialect %d, want %d", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestParseNmtSummary(t *testing.T) {
+
+	tests := []struct {
+		file string
+		want map[string]string
+		// values the JDK does not print
+		missing []string
+	}{
+		{
+			file: "nmt/jdk8_summary.txt",
+			want: map[string]string{
+				"total_reserved_bytes":        "1458197KB",
+				"total_committed_bytes":       "160693KB",
+				"java_heap_reserved_bytes":    "262144KB",
+				"class_classes_total":         "2084",
+				"thread_total":                "20",
+				"thread_stack_reserved_bytes": "19488KB",
+			},
+			missing: []string{"class_instance_classes_total", "total_malloc_bytes"},
+		},
+		{
+			file: "nmt/jdk11_summary.txt",
+			want: map[string]string{
+				"total_reserved_bytes":         "5600414KB",
+				"class_instance_classes_total": "405",
+				"class_array_classes_total":    "75",
+				"class_class_space_used_bytes": "8KB",
+				"thread_total":                 "18",
+			},
+			missing: []string{"total_malloc_bytes"},
+		},
+		{
+			file: "nmt/jdk17_summary.txt",
+			want: map[string]string{
+				"total_reserved_bytes":         "5717439KB",
+				"total_committed_bytes":        "371095KB",
+				"class_instance_classes_total": "914",
+				"class_array_classes_total":    "141",
+				"class_class_space_used_bytes": "118KB",
+				"thread_total":                 "19",
+				"thread_stack_reserved_bytes":  "19456KB",
+			},
+			missing: []string{"total_malloc_bytes", "class_malloc_peak_bytes"},
+		},
+		{
+			file: "nmt/jdk21_summary.txt",
+			want: map[string]string{
+				"total_reserved_bytes":      "5726374KB",
+				"total_malloc_bytes":        "23346KB",
+				"total_malloc_total":        "37522",
+				"total_mmap_reserved_bytes": "5703028KB",
+				"class_malloc_peak_bytes":   "110KB",
+				"thread_arena_peak_bytes":   "1192KB",
+				"thread_total":              "20",
+			},
+		},
+		{
+			file: "nmt/jdk23_summary.txt",
+			want: map[string]string{
+				"total_reserved_bytes":      "5731216KB",
+				"total_malloc_peak_bytes":   "26510KB",
+				"class_mmap_peak_bytes":     "192KB",
+				"java_heap_mmap_peak_bytes": "256000KB",
+				"thread_stack_peak_bytes":   "1092KB",
+				"class_malloc_bytes":        "111KB",
+				"class_malloc_total":        "1990",
+				"thread_total":              "20",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+
+		values := ParseNmtSummary(readExample(t, tt.file))
+
+		for name, want := range tt.want {
+			if got, ok := values[name]; !ok || got != want {
+				t.Errorf("%s: %s = %q, want %q", tt.file, name, got, want)
+			}
+		}
+
+		for _, name := range tt.missing {
+			if got, ok := values[name]; ok {
+				t.Errorf("%s: unexpected %s = %q", tt.file, name, got)
+			}
+		}
+	}
+}
+
+func TestParseNmtSummaryUnits(t *testing.T) {
+
+	// scale=MB, sizes keep their unit for ParseSize
+	values := ParseNmtSummary(readExample(t, "nmt/jdk17_summary_mb.txt"))
+
+	if got := values["total_reserved_bytes"]; got != "5583MB" {
+		t.Errorf("total_reserved_bytes = %q, want %q", got, "5583MB")
+	}
+
+	if got, err := ParseSize(values["total_reserved_bytes"], "KB"); err != nil || got != 5583*1024*1024 {
+		t.Errorf("ParseSize = %v, %v, want %d", got, err, 5583*1024*1024)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
+// ParserFactory creates the parser of a task, e.g. from its extra args.
+type ParserFactory func(task *JcmdTask) Parser
+
+var parsers = make(map[string]ParserFactory)
+
+// RegisterParser makes factory create the parsers of tasks running the jcmd
+// command, usually from an init function next to the parser.
+func RegisterParser(command string, factory ParserFactory) {
+
+	parsers[command] = factory
+}
+
+// HasParser tells whether defs define or a parser is registered for the
+// jcmd command.
+func HasParser(command string, defs map[string][]ParserDef) bool {
+
+	if _, ok := defs[command]; ok {
+		return true
+	}
+
+	_, ok := parsers[command]
+
+	return ok
+}
+
+// NewParser returns the parser of defs for the subsystem of the task, else
+// the parser registered for it, nil if there is none (see HasParser).
+func NewParser(task *JcmdTask, defs map[string][]ParserDef) Parser {
+
+	if d, ok := defs[task.SubSystem]; ok {
+		return &defParser{defs: d}
+	}
+
+	if factory, ok := parsers[task.SubSystem]; ok {
+		return factory(task)
+	}
+
+	return nil
+}
+
+// defParser is a parser defined in the config, see ParserDef.
+type defParser struct {
+	defs []ParserDef
+}
+
+func (p *defParser) Parse(output string) ([]Sample, error) {
+
+	samples := make([]Sample, 0)
+	matched := false
+
+	for i := range p.defs {
+		d := &p.defs[i]
+
+		var matches [][]string
+		if d.Repeat {
+			matches = d.re.FindAllStringSubmatch(output, -1)
+		} else if m := d.re.FindStringSubmatch(output); m != nil {
+			matches = [][]string{m}
+		}
+
+		if len(matches) > 0 {
+			matched = true
+		}
+
+		for _, m := range matches {
+			samples = append(samples, d.samples(m)...)
+		}
+	}
+
+	if !matched {
+		return nil, fmt.Errorf("no parser pattern matched")
+	}
+
+	return samples, nil
+}
+
+// Groups returns the named groups of all patterns which are no labels.
+func (p *defParser) Groups() []string {
+
+	groups := make([]string, 0)
+	for i := range p.defs {
+		groups = append(groups, namedGroups(p.defs[i].re, p.defs[i].Labels)...)
+	}
+
+	return groups
+}
+
+// Labels returns the labels of the first pattern with the group.
+func (p *defParser) Labels(group string) []string {
+
+	for i := range p.defs {
+		for _, name := range namedGroups(p.defs[i].re, p.defs[i].Labels) {
+			if name == group {
+				return p.defs[i].Labels
+			}
+		}
+	}
+
+	return nil
+}
+
+// samples returns one sample per named group of a match which is not a
+// label.
+func (d *ParserDef) samples(m []string) []Sample {
+
+	var labels map[string]string
+	if len(d.Labels) > 0 {
+		labels = make(map[string]string, len(d.Labels))
+		for _, name := range d.Labels {
+			labels[name] = m[d.re.SubexpIndex(name)]
+		}
+	}
+
+	samples := make([]Sample, 0, len(m))
+
+	for i, name := range d.re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if _, ok := labels[name]; ok {
+			continue
+		}
+		samples = append(samples, Sample{Name: name, Value: m[i], Labels: labels})
+	}
+
+	return samples
+}
+
+func (d *ParserDef) compile() error {
+
+	re, err := regexp.Compile(d.Pattern)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range d.Labels {
+		if !labelNameRE.MatchString(name) {
+			return fmt.Errorf("invalid label name '%s'", name)
+		}
+		if re.SubexpIndex(name) < 0 {
+			return fmt.Errorf("label '%s' is no named group of the pattern", name)
+		}
+	}
+
+	d.re = re
+
+	return nil
+}
+
+func namedGroups(re *regexp.Regexp, labels []string) []string {
+
+	skip := make(map[string]bool, len(labels))
+	for _, name := range labels {
+		skip[name] = true
+	}
+
+	groups := make([]string, 0)
+	for _, name := range re.SubexpNames() {
+		if name != "" && !skip[name] {
+			groups = append(groups, name)
+		}
+	}
+
+	return groups
+}
+
+// parserDefsEqual compares parser definitions without their compiled
+// patterns.
+func parserDefsEqual(a []ParserDef, b []ParserDef) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Pattern != b[i].Pattern || a[i].Repeat != b[i].Repeat ||
+			!reflect.DeepEqual(a[i].Labels, b[i].Labels) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// findSample returns the value of the sample with name and the label
+// label=value, any sample with name if label is "".
+func findSample(samples []Sample, name string, label string, value string) (string, bool) {
+
+	for _, s := range samples {
+		if s.Name == name && (label == "" || s.Labels[label] == value) {
+			return s.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// sampleCase is an expected sample of a parser.
+type sampleCase struct {
+	name  string
+	label string
+	value string
+	want  string
+}
+
+func checkSamples(t *testing.T, samples []Sample, cases []sampleCase) {
+
+	t.Helper()
+
+	for _, c := range cases {
+		got, ok := findSample(samples, c.name, c.label, c.value)
+		if !ok {
+			t.Errorf("no sample %s{%s=%q}", c.name, c.label, c.value)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s{%s=%q} = %q, want %q", c.name, c.label, c.value, got, c.want)
+		}
+	}
+}
+
+func TestNewParser(t *testing.T) {
+
+	defs := map[string][]ParserDef{
+		"Compiler.codecache": {{Pattern: `size=(?P<size>\d+)Kb`}},
+		NMT_COMMAND:          {{Pattern: `reserved=(?P<reserved>\d+)KB`}},
+	}
+	for _, d := range defs {
+		if err := d[0].compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		command string
+		defs    map[string][]ParserDef
+		want    string
+	}{
+		{NMT_COMMAND, nil, "*main.nmtParser"},
+		// a definition wins over the parser in the code
+		{NMT_COMMAND, defs, "*main.defParser"},
+		{"Compiler.codecache", defs, "*main.defParser"},
+		{"Compiler.codecache", nil, "<nil>"},
+	}
+
+	for _, tt := range tests {
+
+		if got := HasParser(tt.command, tt.defs); got != (tt.want != "<nil>") {
+			t.Errorf("%s: has parser %v", tt.command, got)
+		}
+
+		got := fmt.Sprintf("%T", NewParser(&JcmdTask{SubSystem: tt.command}, tt.defs))
+		if got != tt.want {
+			t.Errorf("%s: parser %s, want %s", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestDefParser(t *testing.T) {
+
+	defs := []ParserDef{
+		{Pattern: `(?m)^(?P<segment>\w+): size=(?P<size>\d+)Kb`, Repeat: true, Labels: []string{"segment"}},
+		{Pattern: `total_blobs=(?P<total_blobs>\d+)`},
+	}
+	for i := range defs {
+		if err := defs[i].compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &defParser{defs: defs}
+
+	samples, err := p.Parse("profiled: size=120Kb\nnon_profiled: size=80Kb\n total_blobs=12\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkSamples(t, samples, []sampleCase{
+		{"size", "segment", "profiled", "120"},
+		{"size", "segment", "non_profiled", "80"},
+		{"total_blobs", "", "", "12"},
+	})
+
+	if groups := p.Groups(); len(groups) != 2 || groups[0] != "size" || groups[1] != "total_blobs" {
+		t.Errorf("groups %v, want size and total_blobs", groups)
+	}
+
+	if _, err := p.Parse("nothing"); err == nil {
+		t.Error("no error without a match")
+	}
+
+	bad := ParserDef{Pattern: `(?P<size>\d+)`, Labels: []string{"segment"}}
+	if err := bad.compile(); err == nil {
+		t.Error("no error for a label which is no group")
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Layout of the hsperfdata file as written by HotSpot, see
+// src/hotspot/share/runtime/perfMemory.hpp
+const (
+	PERFDATA_MAGIC         = 0xcafec0c0
+	PERFDATA_PROLOGUE_SIZE = 32
+	PERFDATA_ENTRY_SIZE    = 20
+
+	PERFDATA_TYPE_BYTE = 'B'
+	PERFDATA_TYPE_LONG = 'J'
+
+	PERFDATA_UNITS_NONE   = 1
+	PERFDATA_UNITS_BYTES  = 2
+	PERFDATA_UNITS_TICKS  = 3
+	PERFDATA_UNITS_EVENTS = 4
+	PERFDATA_UNITS_STRING = 5
+	PERFDATA_UNITS_HERTZ  = 6
+
+	PERFDATA_VARIABILITY_CONSTANT  = 1
+	PERFDATA_VARIABILITY_MONOTONIC = 2
+	PERFDATA_VARIABILITY_VARIABLE  = 3
+
+	// ticks per second of the counters with ticks units
+	PERFDATA_FREQUENCY = "sun.os.hrt.frequency"
+
+	DEFAULT_PERFDATA_INCLUDE = `^(sun|java)\.`
+)
+
+type PerfDataEntry struct {
+	Name        string
+	Type        byte
+	Units       byte
+	Variability byte
+	Long        int64
+	String      string
+}
+
+func (e *PerfDataEntry) IsString() bool {
+	return e.Type == PERFDATA_TYPE_BYTE
+}
+
+func ReadPerfDataFile(path string) ([]PerfDataEntry, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePerfData(data)
+}
+
+// ParsePerfData decodes the prologue and all entries of a hsperfdata file.
+// Entries of types other than long scalars and byte arrays (strings) are
+// skipped.
+func ParsePerfData(data []byte) ([]PerfDataEntry, error) {
+
+	if len(data) < PERFDATA_PROLOGUE_SIZE {
+		return nil, fmt.Errorf("perfdata too short: %d bytes", len(data))
+	}
+
+	if magic := binary.BigEndian.Uint32(data[0:4]); magic != PERFDATA_MAGIC {
+		return nil, fmt.Errorf("bad perfdata magic 0x%x", magic)
+	}
+
+	var order binary.ByteOrder = binary.BigEndian
+	if data[4] == 1 {
+		order = binary.LittleEndian
+	}
+
+	if major := data[5]; major != 2 {
+		return nil, fmt.Errorf("unsupported perfdata version %d.%d", major, data[6])
+	}
+
+	entryOffset := int(order.Uint32(data[24:28]))
+	numEntries := int(order.Uint32(data[28:32]))
+
+	entries := make([]PerfDataEntry, 0, numEntries)
+	offset := entryOffset
+
+	for i := 0; i < numEntries; i++ {
+
+		if offset < 0 || offset+PERFDATA_ENTRY_SIZE > len(data) {
+			return nil, fmt.Errorf("perfdata entry %d out of bounds at %d", i, offset)
+		}
+
+		e := data[offset:]
+		entryLength := int(order.Uint32(e[0:4]))
+		nameOffset := int(order.Uint32(e[4:8]))
+		vectorLength := int(order.Uint32(e[8:12]))
+		dataOffset := int(order.Uint32(e[16:20]))
+
+		if entryLength <= 0 || offset+entryLength > len(data) {
+			return nil, fmt.Errorf("perfdata entry %d has bad length %d", i, entryLength)
+		}
+
+		if nameOffset >= entryLength || dataOffset > entryLength {
+			return nil, fmt.Errorf("perfdata entry %d has bad offsets", i)
+		}
+
+		e = e[:entryLength]
+
+		name := e[nameOffset:]
+		if n := bytes.IndexByte(name, 0); n >= 0 {
+			name = name[:n]
+		}
+
+		entry := PerfDataEntry{
+			Name:        string(name),
+			Type:        e[12],
+			Units:       e[14],
+			Variability: e[15],
+		}
+
+		switch {
+		case entry.Type == PERFDATA_TYPE_LONG && vectorLength == 0:
+			if dataOffset+8 > entryLength {
+				return nil, fmt.Errorf("perfdata entry %s has bad data offset", entry.Name)
+			}
+			entry.Long = int64(order.Uint64(e[dataOffset : dataOffset+8]))
+
+		case entry.Type == PERFDATA_TYPE_BYTE && vectorLength > 0:
+			end := dataOffset + vectorLength
+			if end > entryLength {
+				end = entryLength
+			}
+			value := e[dataOffset:end]
+			if n := bytes.IndexByte(value, 0); n >= 0 {
+				value = value[:n]
+			}
+			entry.String = string(value)
+
+		default:
+			offset += entryLength
+			continue
+		}
+
+		entries = append(entries, entry)
+		offset += entryLength
+	}
+
+	return entries, nil
+}
+
+// perfdataCollector exports the hsperfdata counters of the JVMs of all
+// running tasks. The metric set depends on the JVMs, so it is an unchecked
+// collector.
+type perfdataCollector struct {
+	app *Application
+}
+
+func (c *perfdataCollector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+func (c *perfdataCollector) Collect(ch chan<- prometheus.Metric) {
+
+	config, targets := c.app.perfdataTargets()
+	if !config.Enabled || len(targets) == 0 {
+		return
+	}
+
+	labelNames := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, t := range targets {
+		for name := range t.labels {
+			if !seen[name] {
+				seen[name] = true
+				labelNames = append(labelNames, name)
+			}
+		}
+	}
+	sort.Strings(labelNames)
+
+	for _, t := range targets {
+
+		entries, err := ReadPerfDataFile(t.path)
+		if err != nil {
+			log.Printf("ERROR can not read perfdata %s - %v\n", t.path, err)
+			continue
+		}
+
+		labelValues := make([]string, len(labelNames))
+		for i, name := range labelNames {
+			labelValues[i] = t.labels[name]
+		}
+
+		frequency := 0.0
+		for _, e := range entries {
+			if e.Name == PERFDATA_FREQUENCY {
+				frequency = float64(e.Long)
+			}
+		}
+
+		names := make(map[string]bool, len(entries))
+
+		for i := range entries {
+			e := &entries[i]
+
+			if e.IsString() || !config.match(e.Name) {
+				continue
+			}
+
+			name, value := perfdataMetricName(e), float64(e.Long)
+
+			if names[name] {
+				continue
+			}
+			names[name] = true
+
+			if e.Units == PERFDATA_UNITS_TICKS {
+				if frequency <= 0 {
+					continue
+				}
+				value /= frequency
+			}
+
+			valueType := prometheus.GaugeValue
+			if e.Variability == PERFDATA_VARIABILITY_MONOTONIC {
+				valueType = prometheus.CounterValue
+			}
+
+			desc := prometheus.NewDesc(name, "hsperfdata counter "+e.Name, labelNames, nil)
+
+			m, err := prometheus.NewConstMetric(desc, valueType, value, labelValues...)
+			if err != nil {
+				log.Printf("ERROR perfdata counter %s - %v\n", e.Name, err)
+				continue
+			}
+
+			ch <- m
+		}
+	}
+}
+
+var perfdataInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// perfdataMetricName maps a counter name to a metric name, e.g.
+// sun.gc.collector.0.time -> jcmd_perfdata_sun_gc_collector_0_time_seconds.
+func perfdataMetricName(e *PerfDataEntry) string {
+
+	name := "jcmd_perfdata_" + perfdataInvalidChars.ReplaceAllString(e.Name, "_")
+
+	switch e.Units {
+	case PERFDATA_UNITS_BYTES:
+		name += "_bytes"
+	case PERFDATA_UNITS_TICKS:
+		name += "_seconds"
+	case PERFDATA_UNITS_HERTZ:
+		name += "_hertz"
+	}
+
+	if e.Variability == PERFDATA_VARIABILITY_MONOTONIC {
+		name += "_total"
+	}
+
+	return name
+}
+
+func (c *PerfDataConfig) prepare() error {
+
+	if c.Include == nil {
+		c.Include = []string{DEFAULT_PERFDATA_INCLUDE}
+	}
+
+	var err error
+
+	if c.include, err = compileRegexps(c.Include); err != nil {
+		return fmt.Errorf("include: %v", err)
+	}
+
+	if c.exclude, err = compileRegexps(c.Exclude); err != nil {
+		return fmt.Errorf("exclude: %v", err)
+	}
+
+	return nil
+}
+
+func (c *PerfDataConfig) match(name string) bool {
+
+	for _, re := range c.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+
+	for _, re := range c.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// perfdataTargets returns the perfdata config and the hsperfdata file and
+// labels of every distinct JVM of the running tasks.
+func (a *Application) perfdataTargets() (PerfDataConfig, []perfdataTarget) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	targets := make([]perfdataTarget, 0)
+	if a.config == nil {
+		return PerfDataConfig{}, targets
+	}
+
+	pids := make(map[int]bool)
+
+	for _, rt := range a.tasks {
+		jvm := rt.task.currentJvm()
+
+		if jvm == nil || pids[jvm.Pid] {
+			continue
+		}
+		pids[jvm.Pid] = true
+
+		targets = append(targets, perfdataTarget{
+			path:   jvm.Path,
+			labels: rt.task.currentLabels(),
+		})
+	}
+
+	return a.config.Perfdata, targets
+}
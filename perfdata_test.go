@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParsePerfData(t *testing.T) {
+
+	long := make([]byte, 8)
+	binary.LittleEndian.PutUint64(long, 1000000000)
+
+	data := perfData(
+		perfEntry("sun.rt.javaCommand", PERFDATA_TYPE_BYTE, PERFDATA_UNITS_STRING, 32, []byte("com.example.App --port 8080\x00")),
+		perfEntry("sun.os.hrt.frequency", PERFDATA_TYPE_LONG, PERFDATA_UNITS_HERTZ, 0, long),
+		// a long vector is skipped
+		perfEntry("sun.gc.vector", PERFDATA_TYPE_LONG, PERFDATA_UNITS_NONE, 2, make([]byte, 16)),
+	)
+
+	entries, err := ParsePerfData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("%d entries, want 2: %v", len(entries), entries)
+	}
+
+	if e := entries[0]; e.Name != "sun.rt.javaCommand" || !e.IsString() || e.String != "com.example.App --port 8080" {
+		t.Errorf("string entry %+v", e)
+	}
+
+	if e := entries[1]; e.Name != PERFDATA_FREQUENCY || e.IsString() || e.Long != 1000000000 || e.Units != PERFDATA_UNITS_HERTZ {
+		t.Errorf("long entry %+v", e)
+	}
+}
+
+func TestParsePerfDataErrors(t *testing.T) {
+
+	good := perfData(perfEntry("sun.rt.javaCommand", PERFDATA_TYPE_BYTE, PERFDATA_UNITS_STRING, 8, []byte("App\x00")))
+
+	badMagic := append([]byte{}, good...)
+	badMagic[0] = 0
+
+	badVersion := append([]byte{}, good...)
+	badVersion[5] = 1
+
+	truncated := good[:len(good)-8]
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short", good[:16]},
+		{"magic", badMagic},
+		{"version", badVersion},
+		{"truncated", truncated},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParsePerfData(tt.data); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestPerfdataMetricName(t *testing.T) {
+
+	tests := []struct {
+		entry PerfDataEntry
+		want  string
+	}{
+		{PerfDataEntry{Name: "sun.gc.collector.0.time", Units: PERFDATA_UNITS_TICKS, Variability: PERFDATA_VARIABILITY_MONOTONIC}, "jcmd_perfdata_sun_gc_collector_0_time_seconds_total"},
+		{PerfDataEntry{Name: "sun.gc.generation.0.space.0.used", Units: PERFDATA_UNITS_BYTES, Variability: PERFDATA_VARIABILITY_VARIABLE}, "jcmd_perfdata_sun_gc_generation_0_space_0_used_bytes"},
+		{PerfDataEntry{Name: "sun.os.hrt.frequency", Units: PERFDATA_UNITS_HERTZ, Variability: PERFDATA_VARIABILITY_CONSTANT}, "jcmd_perfdata_sun_os_hrt_frequency_hertz"},
+		{PerfDataEntry{Name: "java.threads.live", Units: PERFDATA_UNITS_NONE, Variability: PERFDATA_VARIABILITY_VARIABLE}, "jcmd_perfdata_java_threads_live"},
+		{PerfDataEntry{Name: "sun.rt.safepoints", Units: PERFDATA_UNITS_EVENTS, Variability: PERFDATA_VARIABILITY_MONOTONIC}, "jcmd_perfdata_sun_rt_safepoints_total"},
+	}
+
+	for _, tt := range tests {
+		if got := perfdataMetricName(&tt.entry); got != tt.want {
+			t.Errorf("%s: %s, want %s", tt.entry.Name, got, tt.want)
+		}
+	}
+}
+
+func TestPerfDataConfigMatch(t *testing.T) {
+
+	c := PerfDataConfig{Exclude: []string{`^sun\.cls\.`}}
+	if err := c.prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"sun.gc.collector.0.time", true},
+		{"java.threads.live", true},
+		{"sun.cls.time", false},
+		{"com.example.counter", false},
+	}
+
+	for _, tt := range tests {
+		if got := c.match(tt.name); got != tt.want {
+			t.Errorf("%s matched %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
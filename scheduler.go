@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	MISSED_TICK_SKIP     = "skip"
+	MISSED_TICK_CATCH_UP = "catch_up"
+)
+
+var skippedTicks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "jcmd_exporter",
+	Name:      "skipped_ticks_total",
+	Help:      "Number of scheduled collections skipped because the previous one outlasted the interval.",
+}, []string{"task"})
+
+// schedule keeps the tick times of a single task. Ticks are aligned to the
+// first (jittered) start time, so a slow collection shifts only the ticks it
+// overlaps instead of the whole timeline.
+type schedule struct {
+	interval time.Duration
+	jitter   time.Duration
+	policy   string
+	next     time.Time
+	skipped  prometheus.Counter
+}
+
+func newSchedule(task *JcmdTask, now time.Time) *schedule {
+
+	s := &schedule{
+		interval: time.Duration(task.TimerMs) * time.Millisecond,
+		policy:   task.MissedTickPolicy,
+		skipped:  skippedTicks.WithLabelValues(task.Name),
+	}
+
+	if task.JitterMs > 0 {
+		s.jitter = time.Duration(rand.Int63n(int64(task.JitterMs))) * time.Millisecond
+	}
+
+	s.next = now.Add(s.jitter)
+
+	return s
+}
+
+func (s *schedule) firstDelay() time.Duration {
+	return s.jitter
+}
+
+// advance moves to the next tick after a collection has finished at now and
+// returns how long to wait for it. A tick at now still runs. Ticks which
+// have already passed are either dropped (skip) or the latest of them is run
+// immediately (catch_up).
+func (s *schedule) advance(now time.Time) time.Duration {
+
+	s.next = s.next.Add(s.interval)
+
+	if !now.After(s.next) {
+		return s.next.Sub(now)
+	}
+
+	// ticks up to and including now
+	missed := int64(now.Sub(s.next)/s.interval) + 1
+
+	if s.policy == MISSED_TICK_CATCH_UP {
+		if missed > 1 {
+			s.skipped.Add(float64(missed - 1))
+			s.next = s.next.Add(time.Duration(missed-1) * s.interval)
+		}
+		return 0
+	}
+
+	// a tick at now is not missed, it runs right away
+	if now.Sub(s.next)%s.interval == 0 {
+		missed--
+	}
+
+	s.skipped.Add(float64(missed))
+	s.next = s.next.Add(time.Duration(missed) * s.interval)
+
+	return s.next.Sub(now)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestScheduleAdvance(t *testing.T) {
+
+	start := time.Unix(1000, 0)
+	second := time.Second
+
+	tests := []struct {
+		policy string
+		// collection end times relative to start
+		done        []time.Duration
+		wantWait    []time.Duration
+		wantSkipped float64
+	}{
+		// in time, the next tick is one interval after the last
+		{MISSED_TICK_SKIP, []time.Duration{2 * second, 12 * second}, []time.Duration{8 * second, 8 * second}, 0},
+		// ticks at 10s and 20s missed, wait for the one at 30s
+		{MISSED_TICK_SKIP, []time.Duration{25 * second}, []time.Duration{5 * second}, 2},
+		// a collection ending on a tick runs it right away
+		{MISSED_TICK_SKIP, []time.Duration{10 * second}, []time.Duration{0}, 0},
+		{MISSED_TICK_SKIP, []time.Duration{20 * second}, []time.Duration{0}, 1},
+		{MISSED_TICK_CATCH_UP, []time.Duration{10 * second}, []time.Duration{0}, 0},
+		{MISSED_TICK_CATCH_UP, []time.Duration{20 * second}, []time.Duration{0}, 1},
+		// ticks at 10s and 20s missed, 20s is run right away
+		{MISSED_TICK_CATCH_UP, []time.Duration{25 * second}, []time.Duration{0}, 1},
+		// and the timeline stays aligned
+		{MISSED_TICK_CATCH_UP, []time.Duration{25 * second, 26 * second}, []time.Duration{0, 4 * second}, 1},
+		{MISSED_TICK_CATCH_UP, []time.Duration{15 * second}, []time.Duration{0}, 0},
+	}
+
+	for i, tt := range tests {
+
+		s := &schedule{
+			interval: 10 * second,
+			policy:   tt.policy,
+			next:     start,
+			skipped:  prometheus.NewCounter(prometheus.CounterOpts{Name: "skipped"}),
+		}
+
+		for j, done := range tt.done {
+			if got := s.advance(start.Add(done)); got != tt.wantWait[j] {
+				t.Errorf("%d: %s after %v waits %v, want %v", i, tt.policy, done, got, tt.wantWait[j])
+			}
+		}
+
+		if got := testutil.ToFloat64(s.skipped); got != tt.wantSkipped {
+			t.Errorf("%d: %s skipped %v, want %v", i, tt.policy, got, tt.wantSkipped)
+		}
+	}
+}
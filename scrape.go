@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const SCRAPE_TIMEOUT_HEADER = "X-Prometheus-Scrape-Timeout-Seconds"
+
+var (
+	scrapeSuccessDesc = prometheus.NewDesc(
+		"jcmd_exporter_scrape_collection_success",
+		"Whether the scrape-synchronous jcmd collection of a task succeeded.",
+		[]string{"task"}, nil,
+	)
+	scrapeDurationDesc = prometheus.NewDesc(
+		"jcmd_exporter_scrape_collection_duration_seconds",
+		"Duration of the scrape-synchronous jcmd collection of a task.",
+		[]string{"task"}, nil,
+	)
+)
+
+// resultCache shares one collection between concurrent scrapers of a task
+// and optionally keeps its result for cacheTTL.
+type resultCache struct {
+	mu       sync.Mutex
+	inflight *cacheCall
+	err      error
+	at       time.Time
+}
+
+type cacheCall struct {
+	done chan struct{}
+	err  error
+}
+
+func (c *resultCache) Do(ctx context.Context, ttl time.Duration, fn func() error) error {
+
+	c.mu.Lock()
+
+	if !c.at.IsZero() && time.Since(c.at) < ttl {
+		err := c.err
+		c.mu.Unlock()
+		return err
+	}
+
+	call := c.inflight
+	if call == nil {
+		call = &cacheCall{done: make(chan struct{})}
+		c.inflight = call
+		c.mu.Unlock()
+
+		call.err = fn()
+
+		c.mu.Lock()
+		c.inflight = nil
+		c.err = call.err
+		c.at = time.Now()
+		c.mu.Unlock()
+
+		close(call.done)
+		return call.err
+	}
+
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// scrapeCollector runs jcmd for every scrape mode task during Collect. It
+// only updates the task gauges, which are exported by the default registry,
+// so it has to be gathered before it (see metricsHandler).
+type scrapeCollector struct {
+	ctx     context.Context
+	app     *Application
+	timeout time.Duration
+}
+
+func (c *scrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- scrapeSuccessDesc
+	ch <- scrapeDurationDesc
+}
+
+func (c *scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+
+	var wg sync.WaitGroup
+
+	for _, task := range c.app.scrapeTasks() {
+		wg.Add(1)
+
+		go func(task *JcmdTask) {
+			defer wg.Done()
+
+			timeout := time.Duration(task.TimeoutMs) * time.Millisecond
+			if c.timeout > 0 && c.timeout < timeout {
+				timeout = c.timeout
+			}
+
+			start := time.Now()
+			err := task.cache.Do(c.ctx, time.Duration(task.CacheMs)*time.Millisecond, func() error {
+				return task.Collect(c.app.ctx, timeout)
+			})
+
+			success := 0.0
+			if err == nil {
+				success = 1.0
+			}
+
+			ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, task.Name)
+			ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, time.Since(start).Seconds(), task.Name)
+		}(task)
+	}
+
+	wg.Wait()
+}
+
+// metricsHandler serves /metrics. Each request gets its own registry with a
+// scrapeCollector bound to the request context and the scrape timeout sent
+// by Prometheus, reduced by offset to leave time for the response.
+func (a *Application) metricsHandler(offset time.Duration) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		var timeout time.Duration
+
+		if v := r.Header.Get(SCRAPE_TIMEOUT_HEADER); v != "" {
+			if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+				timeout = time.Duration(seconds*float64(time.Second)) - offset
+				if timeout <= 0 {
+					timeout = time.Duration(seconds * float64(time.Second))
+				}
+			}
+		}
+
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(&scrapeCollector{ctx: r.Context(), app: a, timeout: timeout})
+
+		// Gatherers are gathered in order, so the task gauges are updated
+		// before the default registry reads them.
+		gatherers := prometheus.Gatherers{reg, prometheus.DefaultGatherer}
+
+		promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeExecutor returns output, or err, after delay and records its calls.
+type fakeExecutor struct {
+	output string
+	err    error
+	delay  time.Duration
+
+	mu       sync.Mutex
+	calls    int
+	timeouts []time.Duration
+}
+
+func (e *fakeExecutor) Execute(ctx context.Context, timeout time.Duration, target string, command []string) (string, error) {
+
+	e.mu.Lock()
+	e.calls++
+	e.timeouts = append(e.timeouts, timeout)
+	e.mu.Unlock()
+
+	select {
+	case <-time.After(e.delay):
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	return e.output, e.err
+}
+
+func TestResultCacheShared(t *testing.T) {
+
+	var c resultCache
+	calls := 0
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	fn := func() error {
+		calls++
+		close(started)
+		<-release
+		return fmt.Errorf("failed")
+	}
+
+	errs := make(chan error, 3)
+	go func() { errs <- c.Do(context.Background(), 0, fn) }()
+	<-started
+
+	// both wait for the running call instead of starting their own
+	for i := 0; i < 2; i++ {
+		go func() { errs <- c.Do(context.Background(), 0, fn) }()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err == nil || err.Error() != "failed" {
+			t.Errorf("error %v, want the one of the shared call", err)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("%d calls, want 1", calls)
+	}
+}
+
+func TestResultCacheTTL(t *testing.T) {
+
+	var c resultCache
+	calls := 0
+	fn := func() error {
+		calls++
+		return nil
+	}
+
+	c.Do(context.Background(), time.Hour, fn)
+	c.Do(context.Background(), time.Hour, fn)
+	if calls != 1 {
+		t.Errorf("%d calls within the TTL, want 1", calls)
+	}
+
+	// without a TTL every scrape collects
+	c.Do(context.Background(), 0, fn)
+	c.Do(context.Background(), 0, fn)
+	if calls != 3 {
+		t.Errorf("%d calls without TTL, want 3", calls)
+	}
+}
+
+func TestResultCacheContext(t *testing.T) {
+
+	var c resultCache
+	release := make(chan struct{})
+	started := make(chan struct{})
+	defer close(release)
+
+	go c.Do(context.Background(), 0, func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	// a scraper which gives up does not wait for the running call
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := c.Do(ctx, 0, func() error { return nil }); err != context.DeadlineExceeded {
+		t.Errorf("error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestScrapeTimeout(t *testing.T) {
+
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		// the task timeout
+		{"", 5 * time.Second},
+		{"10", 5 * time.Second},
+		// the scrape timeout less the offset
+		{"2", 1500 * time.Millisecond},
+		// the scrape timeout if the offset would leave nothing
+		{"0.25", 250 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+
+		executor := &fakeExecutor{err: fmt.Errorf("failed")}
+		task := &JcmdTask{
+			Name:       "a",
+			Executor:   executor,
+			Pid:        os.Getpid(),
+			SubSystem:  NMT_COMMAND,
+			TimeoutMs:  5000,
+			Mode:       MODE_SCRAPE,
+			StaleAfter: 3,
+			Metrics:    &metricsMap{},
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		a := &Application{ctx: ctx, cancel: cancel, tasks: map[string]*runningTask{"a": {task: task}}}
+
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if tt.header != "" {
+			r.Header.Set(SCRAPE_TIMEOUT_HEADER, tt.header)
+		}
+		w := httptest.NewRecorder()
+
+		a.metricsHandler(500*time.Millisecond).ServeHTTP(w, r)
+		cancel()
+
+		if len(executor.timeouts) != 1 || executor.timeouts[0] != tt.want {
+			t.Errorf("header %q: timeouts %v, want %v", tt.header, executor.timeouts, tt.want)
+		}
+
+		body, _ := ioutil.ReadAll(w.Result().Body)
+		if !strings.Contains(string(body), `jcmd_exporter_scrape_collection_success{task="a"} 0`) {
+			t.Errorf("header %q: no failed collection in\n%s", tt.header, body)
+		}
+	}
+}
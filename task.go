@@ -3,54 +3,307 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"os/exec"
-	"regexp"
+	"reflect"
+	"strconv"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-func RunTasks(ctx context.Context, p *regexp.Regexp, tasks []*JcmdTask) {
-
-	for i := range tasks {
-		go func(task *JcmdTask) error {
-
-			ticker := time.NewTicker(500 * time.Millisecond)
-			defer ticker.Stop()
-
-			for {
-				select {
-				case <-ctx.Done():
-					return ctx.Err() // TODO do we need to return error in goroutine?
-				case t := <-ticker.C:
-					fmt.Println("Tick at", t)
-					output, err := CallJcmd(
-						ctx,
-						time.Duration(task.TimeoutMs)*time.Millisecond,
-						task.PathJcmd,
-						task.MainClass,
-						task.SubSystem,
-					)
-
-					if err != nil {
-						fmt.Println("error", err)
-						continue
-					}
-					parse_response(output, p, task.Metrics)
-
-					fmt.Println("Finished", t)
-				}
+// RunTask starts the collection loop of a task. The returned channel is
+// closed once the loop has exited after ctx is cancelled. Collections of one
+// task never overlap: the next one is scheduled only after the previous one
+// has finished.
+func RunTask(ctx context.Context, task *JcmdTask) <-chan struct{} {
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		s := newSchedule(task, time.Now())
+
+		timer := time.NewTimer(s.firstDelay())
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+				task.Collect(ctx, time.Duration(task.TimeoutMs)*time.Millisecond)
+				timer.Reset(s.advance(time.Now()))
 			}
-		}(tasks[i])
+		}
+	}()
+
+	return done
+}
+
+// Collect runs jcmd once and updates the task series. After StaleAfter
+// failed collections in a row the series are deleted, and they are deleted
+// right away when the target process is gone.
+func (t *JcmdTask) Collect(ctx context.Context, timeout time.Duration) error {
+
+	t.collectMu.Lock()
+	defer t.collectMu.Unlock()
+
+	if t.stopped {
+		return fmt.Errorf("task %s is stopped", t.Name)
+	}
+
+	err := t.collect(ctx, timeout)
+
+	if err == nil {
+		t.failures = 0
+		return nil
+	}
+
+	fmt.Printf("ERROR task %s - %v\n", t.Name, err)
+
+	t.failures++
+	if t.failures == t.StaleAfter {
+		log.Printf("INFO task %s failed %d times, removing its series\n", t.Name, t.failures)
+		t.Metrics.Delete()
+		t.setDetail(nil)
+	}
+
+	return err
+}
+
+// Stop waits for a running collection, deletes the task series and makes
+// later collections fail, so that no series of a stopped task reappear.
+func (t *JcmdTask) Stop() {
+
+	t.collectMu.Lock()
+	defer t.collectMu.Unlock()
+
+	t.stopped = true
+	t.Metrics.Delete()
+}
+
+func (t *JcmdTask) collect(ctx context.Context, timeout time.Duration) error {
+
+	// a main class target may be restarted with another pid, the series of
+	// the old one are deleted by SetLabels
+	if t.Pid == 0 {
+		labels, jvm := t.resolveLabels()
+		if !reflect.DeepEqual(labels, t.currentLabels()) {
+			t.setLabels(labels, jvm)
+			t.resetBaseline()
+		}
+	} else if !processAlive(t.Pid) {
+		t.Metrics.Delete()
+		t.setDetail(nil)
+		return fmt.Errorf("process %d is gone", t.Pid)
+	}
+
+	// executor "none" only tracks the JVM, e.g. for its perfdata counters
+	if t.Executor == nil {
+		return nil
+	}
+
+	// e.g. GC.class_histogram runs a full GC, until MinIntervalMs passed
+	// the series keep their last values
+	if !t.lastRunAt.IsZero() && time.Since(t.lastRunAt) < time.Duration(t.MinIntervalMs)*time.Millisecond {
+		return nil
+	}
+	t.lastRunAt = time.Now()
+
+	if t.Baseline {
+		if err := t.ensureBaseline(ctx, timeout); err != nil {
+			return err
+		}
+	}
+
+	samples, err := t.execute(ctx, timeout)
+	if err != nil {
+		return err
+	}
+
+	return t.Metrics.Update(samples)
+}
+
+// execute runs the command of the task and parses its output, while it is
+// read if both the executor and the parser can stream.
+func (t *JcmdTask) execute(ctx context.Context, timeout time.Duration) ([]Sample, error) {
+
+	executor, ok := t.Executor.(StreamExecutor)
+	parser, ok2 := t.Parser.(StreamParser)
+
+	if ok && ok2 {
+		var samples []Sample
+
+		err := executor.ExecuteStream(ctx, timeout, t.Target(), t.Command(), func(r io.Reader) error {
+			var err error
+			samples, err = parser.ParseStream(r)
+			return err
+		})
+
+		return samples, err
+	}
+
+	output, err := t.Executor.Execute(ctx, timeout, t.Target(), t.Command())
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Parser.Parse(output)
+}
+
+// resolveLabels returns the series labels of the task: pid, main_class and
+// user of the target JVM as far as they can be found in hsperfdata, then
+// the static labels, and "" for labels other targets of the set define.
+// The JVM found, if any, is returned too.
+func (t *JcmdTask) resolveLabels() (prometheus.Labels, *JvmInfo) {
+
+	labels := make(prometheus.Labels, len(t.labelNames))
+
+	for _, name := range t.labelNames {
+		labels[name] = ""
+	}
+
+	for name, value := range t.Labels {
+		labels[name] = value
 	}
+
+	labels[LABEL_MAIN_CLASS] = t.MainClass
+	if t.Pid > 0 {
+		labels[LABEL_PID] = strconv.Itoa(t.Pid)
+	}
+
+	jvm := FindJvm(t.HsperfdataDir, t.Pid, t.MainClass)
+	if jvm != nil {
+		labels[LABEL_PID] = strconv.Itoa(jvm.Pid)
+		labels[LABEL_MAIN_CLASS] = jvm.MainClass
+		labels[LABEL_USER] = jvm.User
+	}
+
+	return labels, jvm
+}
+
+func (t *JcmdTask) currentLabels() prometheus.Labels {
+
+	t.labelsMu.Lock()
+	defer t.labelsMu.Unlock()
+
+	return t.labels
+}
+
+func (t *JcmdTask) currentJvm() *JvmInfo {
+
+	t.labelsMu.Lock()
+	defer t.labelsMu.Unlock()
+
+	return t.jvm
+}
+
+func (t *JcmdTask) setLabels(labels prometheus.Labels, jvm *JvmInfo) {
+
+	t.labelsMu.Lock()
+	// the JVM of another pid has not run the command yet, e.g. a restarted
+	// one is not left without a class histogram for MinIntervalMs
+	if t.labels[LABEL_PID] != labels[LABEL_PID] {
+		t.lastRunAt = time.Time{}
+	}
+	t.labels = labels
+	t.jvm = jvm
+	t.labelsMu.Unlock()
+
+	t.Metrics.SetLabels(labels)
+}
+
+// Target returns the pid or, if there is none, the main class of the task.
+func (t *JcmdTask) Target() string {
+
+	if t.Pid > 0 {
+		return strconv.Itoa(t.Pid)
+	}
+
+	return t.MainClass
+}
+
+// Command returns the diagnostic command: the subsystem followed by any
+// extra arguments. Baseline tasks run summary.diff instead of summary,
+// AllObjects adds -all.
+func (t *JcmdTask) Command() []string {
+
+	command := make([]string, 0, 2+len(t.ExtraArgs))
+	command = append(command, t.SubSystem)
+
+	if t.AllObjects {
+		command = append(command, CLASS_HISTOGRAM_ALL)
+		for _, arg := range t.ExtraArgs {
+			if arg != CLASS_HISTOGRAM_ALL {
+				command = append(command, arg)
+			}
+		}
+		return command
+	}
+
+	if !t.Baseline {
+		return append(command, t.ExtraArgs...)
+	}
+
+	for _, arg := range t.ExtraArgs {
+		if arg != NMT_SUMMARY && arg != NMT_SUMMARY_DIFF {
+			command = append(command, arg)
+		}
+	}
+
+	return append(command, NMT_SUMMARY_DIFF)
+}
+
+// execExecutor runs the jcmd binary.
+type execExecutor struct {
+	path string
+}
+
+func (e *execExecutor) Execute(ctx context.Context, timeout time.Duration, target string, command []string) (string, error) {
+
+	return CallJcmd(ctx, timeout, e.path, append([]string{target}, command...))
+}
+
+func (e *execExecutor) ExecuteStream(ctx context.Context, timeout time.Duration, target string, command []string, parse func(io.Reader) error) error {
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.path, append([]string{target}, command...)...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	parseErr := parse(stdout)
+
+	// read the rest, jcmd may block on a full pipe otherwise
+	io.Copy(ioutil.Discard, stdout)
+
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+
+	return parseErr
 }
 
-func CallJcmd(ctx context.Context, timeout time.Duration, app string, mainClass string, arg1 string) (string, error) {
+func CallJcmd(ctx context.Context, timeout time.Duration, app string, args []string) (string, error) {
 
 	// TODO do we need "select { case <-ctx.Done()" here ???
 
 	ctx, close := context.WithTimeout(ctx, timeout)
 	defer close()
 
-	cmd := exec.CommandContext(ctx, app, mainClass, arg1)
+	cmd := exec.CommandContext(ctx, app, args...)
 	stdout, err := cmd.Output()
 
 	if err != nil {
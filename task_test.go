@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestVecs registers a metric set of one gauge "value" with labelNames
+// in a registry of its own.
+func newTestVecs(t *testing.T, labelNames []string) *metricVecs {
+
+	t.Helper()
+
+	attrs := []MetricDescAttr{{ReGroup: "value", Name: "value", Help: "A value"}}
+
+	vecs, err := NewMetricVecs(prometheus.NewRegistry(), "test", &attrs, labelNames, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return vecs
+}
+
+func TestSetLabelNames(t *testing.T) {
+
+	targets := []TargetConfig{
+		{Metrics: "a", Labels: map[string]string{"team": "x", "env": "dev"}},
+		{Metrics: "a", Labels: map[string]string{"zone": "1"}},
+		{Metrics: "b", Labels: map[string]string{"other": "y"}},
+	}
+
+	want := []string{LABEL_PID, LABEL_MAIN_CLASS, LABEL_USER, "env", "team", "zone"}
+	if got := SetLabelNames(targets, "a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("label names %v, want %v", got, want)
+	}
+}
+
+func TestResolveLabels(t *testing.T) {
+
+	dir := t.TempDir()
+	writeHsperfdata(t, dir, "app", os.Getpid(), "com.example.App --port 8080")
+
+	pid := strconv.Itoa(os.Getpid())
+	labelNames := []string{LABEL_PID, LABEL_MAIN_CLASS, LABEL_USER, "env", "team"}
+
+	tests := []struct {
+		name      string
+		pid       int
+		mainClass string
+		want      prometheus.Labels
+	}{
+		{"main class", 0, "App",
+			prometheus.Labels{"pid": pid, "main_class": "com.example.App", "user": "app", "env": "dev", "team": ""}},
+		{"pid", os.Getpid(), "",
+			prometheus.Labels{"pid": pid, "main_class": "com.example.App", "user": "app", "env": "dev", "team": ""}},
+		// not running, the labels of the config are kept
+		{"no jvm", 0, "Missing",
+			prometheus.Labels{"pid": "", "main_class": "Missing", "user": "", "env": "dev", "team": ""}},
+		{"no jvm pid", deadPid, "",
+			prometheus.Labels{"pid": strconv.Itoa(deadPid), "main_class": "", "user": "", "env": "dev", "team": ""}},
+	}
+
+	for _, tt := range tests {
+
+		task := &JcmdTask{
+			Pid:           tt.pid,
+			MainClass:     tt.mainClass,
+			HsperfdataDir: dir,
+			Labels:        map[string]string{"env": "dev"},
+			labelNames:    labelNames,
+		}
+
+		labels, jvm := task.resolveLabels()
+		if !reflect.DeepEqual(labels, tt.want) {
+			t.Errorf("%s: labels %v, want %v", tt.name, labels, tt.want)
+		}
+		if (jvm != nil) != (tt.want["user"] != "") {
+			t.Errorf("%s: jvm %+v", tt.name, jvm)
+		}
+	}
+}
+
+func TestMetricsMapLabels(t *testing.T) {
+
+	labelNames := []string{LABEL_PID, LABEL_MAIN_CLASS, LABEL_USER}
+	vecs := newTestVecs(t, labelNames)
+	vec := (*vecs)["value"].Vec
+
+	labels := func(pid string) prometheus.Labels {
+		return prometheus.Labels{"pid": pid, "main_class": "App", "user": "app"}
+	}
+
+	// two JVMs of one metric set have a series each
+	first := vecs.NewMetricsMap(labels("1"))
+	second := vecs.NewMetricsMap(labels("2"))
+
+	for _, m := range []*metricsMap{first, second} {
+		if err := m.Update([]Sample{{Name: "value", Value: "1"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := testutil.CollectAndCount(vec); n != 2 {
+		t.Errorf("%d series, want 2", n)
+	}
+
+	// a restarted JVM moves its series to the new pid
+	first.SetLabels(labels("3"))
+	if n := testutil.CollectAndCount(vec); n != 1 {
+		t.Errorf("%d series after SetLabels, want 1", n)
+	}
+
+	first.Update([]Sample{{Name: "value", Value: "5"}})
+
+	if err := testutil.CollectAndCompare(vec, strings.NewReader(`
+# HELP jcmd_test_value A value
+# TYPE jcmd_test_value gauge
+jcmd_test_value{main_class="App",pid="2",user="app"} 1
+jcmd_test_value{main_class="App",pid="3",user="app"} 5
+`)); err != nil {
+		t.Error(err)
+	}
+
+	second.Delete()
+	if n := testutil.CollectAndCount(vec); n != 1 {
+		t.Errorf("%d series after Delete, want 1", n)
+	}
+}
+
+// fakeParser parses the output with a function.
+type fakeParser func(output string) ([]Sample, error)
+
+func (f fakeParser) Parse(output string) ([]Sample, error) {
+	return f(output)
+}
+
+// valueParser gives the output as the sample "value".
+var valueParser = fakeParser(func(output string) ([]Sample, error) {
+	return []Sample{{Name: "value", Value: output}}, nil
+})
+
+func TestCollectStale(t *testing.T) {
+
+	labelNames := []string{LABEL_PID, LABEL_MAIN_CLASS, LABEL_USER}
+	vecs := newTestVecs(t, labelNames)
+	vec := (*vecs)["value"].Vec
+
+	executor := &fakeExecutor{output: "1"}
+	task := &JcmdTask{
+		Name:       "a",
+		Executor:   executor,
+		Parser:     valueParser,
+		Pid:        os.Getpid(),
+		StaleAfter: 2,
+		Metrics:    vecs.NewMetricsMap(prometheus.Labels{"pid": strconv.Itoa(os.Getpid()), "main_class": "", "user": ""}),
+	}
+
+	collect := func(fail bool, want int) {
+
+		t.Helper()
+
+		executor.err = nil
+		if fail {
+			executor.err = fmt.Errorf("failed")
+		}
+
+		if err := task.Collect(context.Background(), time.Second); (err != nil) != fail {
+			t.Errorf("collect error %v", err)
+		}
+
+		if n := testutil.CollectAndCount(vec); n != want {
+			t.Errorf("%d series, want %d", n, want)
+		}
+	}
+
+	// no series before the first good parse
+	if n := testutil.CollectAndCount(vec); n != 0 {
+		t.Errorf("%d series before the first collection, want 0", n)
+	}
+
+	collect(false, 1)
+	// kept with the last value until StaleAfter failures in a row
+	collect(true, 1)
+	collect(false, 1)
+	collect(true, 1)
+	collect(true, 0)
+	collect(true, 0)
+	// and created again by the next good parse
+	collect(false, 1)
+
+	// a JVM which is gone loses its series right away
+	task.Pid = deadPid
+	if err := task.Collect(context.Background(), time.Second); err == nil {
+		t.Error("no error for a JVM which is gone")
+	}
+	if n := testutil.CollectAndCount(vec); n != 0 {
+		t.Errorf("%d series of a JVM which is gone, want 0", n)
+	}
+}
+
+func TestMetricsMapUpdate(t *testing.T) {
+
+	attrs := []MetricDescAttr{
+		{ReGroup: "used", Name: "used_bytes", Help: "Used", Convert: "bytes", Labels: []string{"space"}},
+		{ReGroup: "total", Name: "total", Help: "Total"},
+	}
+
+	vecs, err := NewMetricVecs(prometheus.NewRegistry(), "test", &attrs, []string{LABEL_PID}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := vecs.NewMetricsMap(prometheus.Labels{"pid": "1"})
+
+	err = m.Update([]Sample{
+		{Name: "used", Value: "1KB", Labels: map[string]string{"space": "eden"}},
+		{Name: "used", Value: "2KB", Labels: map[string]string{"space": "old"}},
+		// samples may name the metric instead of the regex group
+		{Name: "total", Value: "7"},
+		{Name: "unknown", Value: "1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a space missing from the next update is deleted
+	if err := m.Update([]Sample{{Name: "used", Value: "3KB", Labels: map[string]string{"space": "old"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testutil.CollectAndCompare((*vecs)["used"].Vec, strings.NewReader(`
+# HELP jcmd_test_used_bytes Used
+# TYPE jcmd_test_used_bytes gauge
+jcmd_test_used_bytes{pid="1",space="old"} 3072
+`)); err != nil {
+		t.Error(err)
+	}
+
+	if got := testutil.ToFloat64((*vecs)["total"].Vec); got != 7 {
+		t.Errorf("total %v, want 7", got)
+	}
+
+	// none of the samples belongs to the map
+	if err := m.Update([]Sample{{Name: "unknown", Value: "1"}}); err == nil {
+		t.Error("no error without a known sample")
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	THREAD_PRINT_COMMAND     = "Thread.print"
+	THREAD_PRINT_METRICS_SET = "thread_print"
+
+	// owner label of monitors whose owner is not in the dump
+	THREAD_OWNER_UNKNOWN = "unknown"
+)
+
+var (
+	threadHeaderRE   = regexp.MustCompile(`^"(.*)" (.*)$`)
+	threadJavaRE     = regexp.MustCompile(`^#\d+ `)
+	threadStateRE    = regexp.MustCompile(`^\s+java\.lang\.Thread\.State: ([A-Z_]+)`)
+	threadWaitLockRE = regexp.MustCompile(`^\s+- waiting to lock <(0x[0-9a-f]+)>`)
+	threadLockedRE   = regexp.MustCompile(`^\s+- locked <(0x[0-9a-f]+)>`)
+	threadOrdinalRE  = regexp.MustCompile(`^(pool|ForkJoinPool)-\d+-`)
+	threadPoolRE     = regexp.MustCompile(`\d+(\s*\([^)]*\))?$`)
+	threadCpuRE      = regexp.MustCompile(`\bcpu=([\d.]+)ms\b`)
+	threadNidRE      = regexp.MustCompile(`\bnid=(\S+)`)
+)
+
+// states of java.lang.Thread.State, always exported so that their series do
+// not come and go
+var threadStates = []string{"NEW", "RUNNABLE", "BLOCKED", "WAITING", "TIMED_WAITING", "TERMINATED"}
+
+func init() {
+	RegisterParser(THREAD_PRINT_COMMAND, newThreadPrintParser)
+}
+
+// threadPrintParser parses Thread.print line by line, the dump of a JVM
+// with thousands of threads is never held in memory as a whole.
+//
+// The CPU time printed per thread since JDK 11 is summed up per pool as a
+// counter: only the growth since the last dump is added, so the CPU time
+// of threads which died in between stays in their pool.
+type threadPrintParser struct {
+	task *JcmdTask
+
+	mu      sync.Mutex
+	pid     string
+	threads map[string]float64
+	poolCpu map[string]float64
+}
+
+func newThreadPrintParser(task *JcmdTask) Parser {
+	return &threadPrintParser{task: task}
+}
+
+func (p *threadPrintParser) Groups() []string {
+
+	return []string{"threads", "daemon_threads", "non_daemon_threads", "vm_threads", "pool_threads", "blocked_threads", "deadlocks", "pool_cpu"}
+}
+
+func (p *threadPrintParser) Labels(group string) []string {
+
+	switch group {
+	case "threads":
+		return []string{"state"}
+	case "pool_threads", "pool_cpu":
+		return []string{"pool"}
+	case "blocked_threads":
+		return []string{"owner"}
+	}
+
+	return nil
+}
+
+func (p *threadPrintParser) Parse(output string) ([]Sample, error) {
+
+	return p.ParseStream(strings.NewReader(output))
+}
+
+func (p *threadPrintParser) ParseStream(r io.Reader) ([]Sample, error) {
+
+	states := make(map[string]int, len(threadStates))
+	for _, state := range threadStates {
+		states[state] = 0
+	}
+
+	pools := make(map[string]int)
+	cpu := make(map[string]threadCpu)
+	owners := make(map[string]string)
+	waiting := make(map[string]int)
+
+	daemon, nonDaemon, vm, deadlocks := 0, 0, 0, 0
+	thread := ""
+	dump := false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, "Full thread dump ") {
+			dump = true
+			continue
+		}
+
+		if strings.HasPrefix(line, "Found one Java-level deadlock:") {
+			deadlocks++
+			thread = ""
+			continue
+		}
+
+		// the deadlock section repeats threads as "name":
+		if m := threadHeaderRE.FindStringSubmatch(line); m != nil && deadlocks == 0 {
+			thread = m[1]
+			pool := threadPool(thread)
+			pools[pool]++
+
+			if c := threadCpuRE.FindStringSubmatch(m[2]); c != nil {
+				id := thread
+				if n := threadNidRE.FindStringSubmatch(m[2]); n != nil {
+					id = n[1] + " " + thread
+				}
+				ms, _ := strconv.ParseFloat(c[1], 64)
+				cpu[id] = threadCpu{pool: pool, seconds: ms / 1000}
+			}
+
+			if !threadJavaRE.MatchString(m[2]) {
+				vm++
+			} else if strings.Contains(" "+m[2]+" ", " daemon ") {
+				daemon++
+			} else {
+				nonDaemon++
+			}
+			continue
+		}
+
+		if thread == "" {
+			continue
+		}
+
+		if m := threadStateRE.FindStringSubmatch(line); m != nil {
+			states[m[1]]++
+		} else if m := threadWaitLockRE.FindStringSubmatch(line); m != nil {
+			waiting[m[1]]++
+		} else if m := threadLockedRE.FindStringSubmatch(line); m != nil {
+			owners[m[1]] = thread
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if !dump {
+		return nil, fmt.Errorf("no thread dump in output")
+	}
+
+	// a monitor is printed as locked by its owner, which may come after
+	// the threads waiting for it; owners are counted by pool, like threads
+	blocked := make(map[string]int)
+	for monitor, count := range waiting {
+		owner := THREAD_OWNER_UNKNOWN
+		if thread, ok := owners[monitor]; ok {
+			owner = threadPool(thread)
+		}
+		blocked[owner] += count
+	}
+
+	poolCpu := p.addCpu(cpu)
+
+	samples := make([]Sample, 0, len(states)+2*len(pools)+len(blocked)+4)
+
+	for state, count := range states {
+		samples = append(samples, Sample{Name: "threads", Value: strconv.Itoa(count), Labels: map[string]string{"state": state}})
+	}
+	for pool, count := range pools {
+		samples = append(samples, Sample{Name: "pool_threads", Value: strconv.Itoa(count), Labels: map[string]string{"pool": pool}})
+	}
+	for pool, seconds := range poolCpu {
+		samples = append(samples, Sample{Name: "pool_cpu", Value: strconv.FormatFloat(seconds, 'f', -1, 64), Labels: map[string]string{"pool": pool}})
+	}
+	for owner, count := range blocked {
+		samples = append(samples, Sample{Name: "blocked_threads", Value: strconv.Itoa(count), Labels: map[string]string{"owner": owner}})
+	}
+
+	samples = append(samples,
+		Sample{Name: "daemon_threads", Value: strconv.Itoa(daemon)},
+		Sample{Name: "non_daemon_threads", Value: strconv.Itoa(nonDaemon)},
+		Sample{Name: "vm_threads", Value: strconv.Itoa(vm)},
+		Sample{Name: "deadlocks", Value: strconv.Itoa(deadlocks)},
+	)
+
+	return samples, nil
+}
+
+type threadCpu struct {
+	pool    string
+	seconds float64
+}
+
+// addCpu adds the CPU time the threads, keyed by nid and name, used since
+// the last dump to their pools and returns the CPU time of all pools seen
+// so far. Threads new since then add all of their CPU time, as do threads
+// whose nid was reused. A new JVM starts from scratch.
+func (p *threadPrintParser) addCpu(threads map[string]threadCpu) map[string]float64 {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pid := p.task.currentLabels()[LABEL_PID]
+	if p.poolCpu == nil || pid != p.pid {
+		p.pid = pid
+		p.threads = make(map[string]float64)
+		p.poolCpu = make(map[string]float64)
+	}
+
+	last := p.threads
+	p.threads = make(map[string]float64, len(threads))
+
+	for id, thread := range threads {
+		growth := thread.seconds
+		if prev, ok := last[id]; ok && prev <= thread.seconds {
+			growth -= prev
+		}
+
+		p.poolCpu[thread.pool] += growth
+		p.threads[id] = thread.seconds
+	}
+
+	poolCpu := make(map[string]float64, len(p.poolCpu))
+	for pool, seconds := range p.poolCpu {
+		poolCpu[pool] = seconds
+	}
+
+	return poolCpu
+}
+
+// threadPool replaces the index of a thread and the ordinal of an executor
+// created at runtime by "*", so that pools do not add a series each, e.g.
+// "pool-37-thread-1" is pool "pool-*-thread-*", "C2 CompilerThread0" is
+// "C2 CompilerThread*" and "GC task thread#0 (ParallelGC)" is
+// "GC task thread#* (ParallelGC)". Other numbers like the port of
+// "http-nio-8080-exec-1" are kept.
+func threadPool(name string) string {
+
+	name = threadOrdinalRE.ReplaceAllString(name, "$1-*-")
+
+	return threadPoolRE.ReplaceAllString(name, "*$1")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestThreadPrintParser(t *testing.T) {
+
+	tests := []struct {
+		file  string
+		cases []sampleCase
+	}{
+		{"thread_print/jdk8.txt", []sampleCase{
+			{"threads", "state", "RUNNABLE", "3"},
+			{"threads", "state", "WAITING", "2"},
+			{"threads", "state", "TIMED_WAITING", "1"},
+			{"threads", "state", "BLOCKED", "0"},
+			{"pool_threads", "pool", "GC task thread#* (ParallelGC)", "2"},
+			{"pool_threads", "pool", "C2 CompilerThread*", "1"},
+			{"daemon_threads", "", "", "5"},
+			{"non_daemon_threads", "", "", "1"},
+			{"vm_threads", "", "", "4"},
+			{"deadlocks", "", "", "0"},
+		}},
+		{"thread_print/jdk17.txt", []sampleCase{
+			{"threads", "state", "RUNNABLE", "4"},
+			{"threads", "state", "BLOCKED", "4"},
+			{"threads", "state", "WAITING", "2"},
+			{"threads", "state", "TIMED_WAITING", "2"},
+			{"pool_threads", "pool", "http-nio-8080-exec-*", "3"},
+			{"pool_threads", "pool", "Thread-*", "2"},
+			{"pool_threads", "pool", "GC Thread#*", "2"},
+			// owners by pool, like the threads
+			{"blocked_threads", "owner", "http-nio-8080-exec-*", "2"},
+			{"blocked_threads", "owner", "Thread-*", "2"},
+			{"daemon_threads", "", "", "9"},
+			{"non_daemon_threads", "", "", "3"},
+			{"vm_threads", "", "", "6"},
+			{"deadlocks", "", "", "1"},
+		}},
+		{"thread_print/jdk21.txt", []sampleCase{
+			{"threads", "state", "RUNNABLE", "4"},
+			{"threads", "state", "WAITING", "3"},
+			{"pool_threads", "pool", "pool-*-thread-*", "2"},
+			{"pool_threads", "pool", "G1 Service", "1"},
+			{"daemon_threads", "", "", "6"},
+			{"deadlocks", "", "", "0"},
+		}},
+	}
+
+	for _, tt := range tests {
+
+		samples, err := newThreadPrintParser(&JcmdTask{}).Parse(readExample(t, tt.file))
+		if err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+			continue
+		}
+
+		t.Run(tt.file, func(t *testing.T) {
+			checkSamples(t, samples, tt.cases)
+		})
+	}
+}
+
+func TestThreadPrintCpu(t *testing.T) {
+
+	p := newThreadPrintParser(&JcmdTask{})
+	dump := readExample(t, "thread_print/jdk17.txt")
+
+	if _, err := p.Parse(dump); err != nil {
+		t.Fatal(err)
+	}
+
+	// main grows by 100ms, Thread-0 ends and keeps its time in its pool
+	dump = strings.Replace(dump, `"main" #1 prio=5 os_prio=0 cpu=61.42ms`, `"main" #1 prio=5 os_prio=0 cpu=161.42ms`, 1)
+	dump = strings.Replace(dump, `"Thread-0" #24 prio=5 os_prio=0 cpu=1.02ms`, `"Gone" #24 prio=5 os_prio=0 cpu=1.02ms`, 1)
+
+	samples, err := p.Parse(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for pool, want := range map[string]float64{"main": 0.16142, "Thread-*": 0.002} {
+		value, _ := findSample(samples, "pool_cpu", "pool", pool)
+		got, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.Abs(got-want) > 1e-9 {
+			t.Errorf("pool_cpu{pool=%q} = %q, want %v", pool, value, want)
+		}
+	}
+}
+
+func TestThreadPool(t *testing.T) {
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"pool-37-thread-1", "pool-*-thread-*"},
+		{"C2 CompilerThread0", "C2 CompilerThread*"},
+		{"GC task thread#0 (ParallelGC)", "GC task thread#* (ParallelGC)"},
+		{"ForkJoinPool-1-worker-3", "ForkJoinPool-*-worker-*"},
+		// the port is no ordinal
+		{"http-nio-8080-exec-3", "http-nio-8080-exec-*"},
+		{"main", "main"},
+	}
+
+	for _, tt := range tests {
+		if got := threadPool(tt.name); got != tt.want {
+			t.Errorf("threadPool(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"io"
 	"os"
+	"regexp"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -9,28 +14,255 @@ import (
 type ConvertFunction func(string) (float64, error)
 
 type MetricDescAttr struct {
-	ReGroup string `json:"regex_group"`
-	Name    string `json:"name"`
-	Help    string `json:"help"`
-	Convert string `json:"convert"`
+	ReGroup string   `json:"regex_group" yaml:"regex_group"`
+	Name    string   `json:"name" yaml:"name"`
+	Help    string   `json:"help" yaml:"help"`
+	Convert string   `json:"convert" yaml:"convert"`
+	Type    string   `json:"type,omitempty" yaml:"type"`
+	Labels  []string `json:"labels,omitempty" yaml:"labels"`
+}
+
+type MetricVec struct {
+	Name      string
+	Vec       *valueVec
+	ConvertFn ConvertFunction
 }
 
 type Metric struct {
-	Gauge     *prometheus.Gauge
+	Name      string
+	Vec       *valueVec
+	Labels    prometheus.Labels
 	ConvertFn ConvertFunction
-	// TODO labels set
+	set       bool
+
+	// series of samples with labels of their own
+	series map[string]prometheus.Labels
+}
+
+// JcmdExecutor runs a diagnostic command in the JVM identified by target,
+// a pid or a main class.
+type JcmdExecutor interface {
+	Execute(ctx context.Context, timeout time.Duration, target string, command []string) (string, error)
+}
+
+// StreamExecutor is an executor which can hand the output to parse while
+// it is read, e.g. for the large output of Thread.print.
+type StreamExecutor interface {
+	ExecuteStream(ctx context.Context, timeout time.Duration, target string, command []string, parse func(io.Reader) error) error
+}
+
+// Sample is one value parsed from jcmd output. Name selects the metric of
+// the task set by regex_group or else by name, Labels are added to the
+// labels of the task.
+type Sample struct {
+	Name   string
+	Value  string
+	Labels map[string]string
+}
+
+// Parser turns the output of one jcmd command into samples.
+type Parser interface {
+	Parse(output string) ([]Sample, error)
+}
+
+// StreamParser is a parser which reads the output as it comes, used with
+// a StreamExecutor.
+type StreamParser interface {
+	Parser
+	ParseStream(r io.Reader) ([]Sample, error)
+}
+
+// GroupsParser is a parser whose sample names and their label names are
+// known before parsing, e.g. the named groups of a pattern, so that metric
+// sets can be checked against them.
+type GroupsParser interface {
+	Parser
+	Groups() []string
+	Labels(group string) []string
 }
 
 type JcmdTask struct {
-	PathJcmd     string
-	PathExtaArgs string // TODO
-	MainClass    string
-	SubSystem    string
-	TimerMs      int
-	TimeoutMs    int
-	Metrics      *metricsMap
+	Name      string
+	Executor  JcmdExecutor
+	Parser    Parser
+	PathJcmd  string
+	ExtraArgs []string
+	MainClass string
+	Pid       int
+	SubSystem string
+	TimerMs   int
+	TimeoutMs int
+	JitterMs  int
+
+	MissedTickPolicy string
+
+	Mode    string
+	CacheMs int
+	cache   resultCache
+
+	// held during a collection, scrape mode tasks are collected by the
+	// HTTP handlers and may still be collecting when they are stopped
+	collectMu sync.Mutex
+	stopped   bool
+
+	HsperfdataDir string
+	Labels        map[string]string
+	labelNames    []string
+	labelsMu      sync.Mutex
+	labels        prometheus.Labels
+	jvm           *JvmInfo
+
+	StaleAfter int
+	failures   int
+
+	TopN         int
+	FrameDepth   int
+	PackageDepth int
+	detailMu     sync.Mutex
+	detail       *NmtDetail
+
+	MinIntervalMs int
+	lastRunAt     time.Time
+	AllObjects    bool
+
+	Baseline           bool
+	BaselineIntervalMs int
+	baselineMu         sync.Mutex
+	baselineAt         time.Time
+
+	Metrics *metricsMap
+}
+
+// TargetConfig describes one jcmd target in the config file. Zero values are
+// filled from the "defaults" section and then from the built-in defaults.
+type TargetConfig struct {
+	Name      string   `yaml:"name"`
+	Executor  string   `yaml:"executor"`
+	PathJcmd  string   `yaml:"jcmd_path"`
+	ExtraArgs []string `yaml:"extra_args"`
+	MainClass string   `yaml:"main_class"`
+	Pid       int      `yaml:"pid"`
+	SubSystem string   `yaml:"subsystem"`
+	TimerMs   int      `yaml:"timer_ms"`
+	TimeoutMs int      `yaml:"timeout_ms"`
+	JitterMs  *int     `yaml:"jitter_ms"`
+	Metrics   string   `yaml:"metrics"`
+
+	MissedTickPolicy string `yaml:"missed_tick_policy"`
+
+	Mode    string `yaml:"mode"`
+	CacheMs int    `yaml:"cache_ms"`
+
+	Labels     map[string]string `yaml:"labels"`
+	StaleAfter int               `yaml:"stale_after"`
+
+	TopN         int `yaml:"top_n"`
+	FrameDepth   int `yaml:"frame_depth"`
+	PackageDepth int `yaml:"package_depth"`
+
+	MinIntervalMs int   `yaml:"min_interval_ms"`
+	AllObjects    *bool `yaml:"all_objects"`
+
+	Baseline           *bool `yaml:"baseline"`
+	BaselineIntervalMs int   `yaml:"baseline_interval_ms"`
 }
 
-type metricsMap map[string]Metric
+type DiscoveryConfig struct {
+	Enabled       bool         `yaml:"enabled"`
+	IntervalMs    int          `yaml:"interval_ms"`
+	HsperfdataDir string       `yaml:"hsperfdata_dir"`
+	Containers    bool         `yaml:"containers"`
+	Include       []string     `yaml:"include"`
+	Exclude       []string     `yaml:"exclude"`
+	Target        TargetConfig `yaml:"target"`
+
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+type PerfDataConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// ParserDef is one pattern of a declarative parser. Named groups give
+// samples for the metric with that regex_group, or the labels of the
+// samples of a match when listed in labels. With repeat every match of the
+// pattern gives samples, otherwise only the first.
+type ParserDef struct {
+	Pattern string   `yaml:"pattern"`
+	Repeat  bool     `yaml:"repeat"`
+	Labels  []string `yaml:"labels"`
+
+	re *regexp.Regexp
+}
+
+// ConverterDef is a converter defined in the config, either a map from
+// values to numbers or a registered converter with a scale.
+type ConverterDef struct {
+	Convert string             `yaml:"convert"`
+	Scale   *float64           `yaml:"scale"`
+	Map     map[string]float64 `yaml:"map"`
+	Default *float64           `yaml:"default"`
+}
+
+// Definitions are the parsers, keyed by jcmd command, metric sets and
+// converters of a definitions file.
+type Definitions struct {
+	Parsers    map[string][]ParserDef      `yaml:"parsers"`
+	MetricSets map[string][]MetricDescAttr `yaml:"metric_sets"`
+	Converters map[string]ConverterDef     `yaml:"converters"`
+}
+
+type Config struct {
+	Defaults    TargetConfig                `yaml:"defaults"`
+	Targets     []TargetConfig              `yaml:"targets"`
+	Discovery   DiscoveryConfig             `yaml:"discovery"`
+	Perfdata    PerfDataConfig              `yaml:"perfdata"`
+	Definitions []string                    `yaml:"definitions"`
+	Parsers     map[string][]ParserDef      `yaml:"parsers"`
+	MetricSets  map[string][]MetricDescAttr `yaml:"metric_sets"`
+	Converters  map[string]ConverterDef     `yaml:"converters"`
+
+	converters map[string]ConvertFunction
+	warnings   []string
+}
+
+// JvmInfo is a running JVM found through its hsperfdata file.
+type JvmInfo struct {
+	Pid       int
+	User      string
+	MainClass string
+	Args      string
+	Path      string
+}
+
+type metricsMap map[string]*Metric
+
+type metricVecs map[string]MetricVec
 
 type signalHandler func(os.Signal) (bool, int)
+
+type runningTask struct {
+	config  TargetConfig
+	parsers []ParserDef
+	task    *JcmdTask
+	cancel  context.CancelFunc
+	done    <-chan struct{}
+}
+
+type perfdataTarget struct {
+	path   string
+	labels prometheus.Labels
+}
+
+type registeredSet struct {
+	attrs      []MetricDescAttr
+	labelNames []string
+	converters map[string]ConverterDef
+	vecs       *metricVecs
+}
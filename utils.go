@@ -2,11 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
-	"strconv"
+	"sort"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 const DEFAULT_METRICS_JSON string = `{
@@ -605,178 +606,839 @@ const DEFAULT_METRICS_JSON string = `{
 			"name": "synchronization_malloc_total",
 			"help": "jcmd VM.native_memory section Synchronization metric Malloc Total",
 			"convert": ""
+		},
+
+		{
+			"regex_group": "to_malloc_kb",
+			"name": "total_malloc_bytes",
+			"help": "jcmd VM.native_memory section Total metric Malloc Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "to_malloc_total",
+			"name": "total_malloc_total",
+			"help": "jcmd VM.native_memory section Total metric Malloc Total",
+			"convert": ""
+		},
+		{
+			"regex_group": "to_mm_resv_kb",
+			"name": "total_mmap_reserved_bytes",
+			"help": "jcmd VM.native_memory section Total metric Mmap Reserved Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "to_mm_comm_kb",
+			"name": "total_mmap_committed_bytes",
+			"help": "jcmd VM.native_memory section Total metric Mmap Committed Bytes",
+			"convert": "kb_to_bytes"
+		},
+
+		{
+			"regex_group": "ot_resv_kb",
+			"name": "other_reserved_bytes",
+			"help": "jcmd VM.native_memory section Other metric Reserved Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "ot_comm_kb",
+			"name": "other_committed_bytes",
+			"help": "jcmd VM.native_memory section Other metric Committed Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "ot_malloc_kb",
+			"name": "other_malloc_bytes",
+			"help": "jcmd VM.native_memory section Other metric Malloc Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "ot_malloc_total",
+			"name": "other_malloc_total",
+			"help": "jcmd VM.native_memory section Other metric Malloc Total",
+			"convert": ""
+		},
+
+		{
+			"regex_group": "gcs_resv_kb",
+			"name": "gccardset_reserved_bytes",
+			"help": "jcmd VM.native_memory section GCCardSet metric Reserved Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "gcs_comm_kb",
+			"name": "gccardset_committed_bytes",
+			"help": "jcmd VM.native_memory section GCCardSet metric Committed Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "gcs_malloc_kb",
+			"name": "gccardset_malloc_bytes",
+			"help": "jcmd VM.native_memory section GCCardSet metric Malloc Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "gcs_malloc_total",
+			"name": "gccardset_malloc_total",
+			"help": "jcmd VM.native_memory section GCCardSet metric Malloc Total",
+			"convert": ""
+		},
+
+		{
+			"regex_group": "tr_resv_kb",
+			"name": "tracing_reserved_bytes",
+			"help": "jcmd VM.native_memory section Tracing metric Reserved Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "tr_comm_kb",
+			"name": "tracing_committed_bytes",
+			"help": "jcmd VM.native_memory section Tracing metric Committed Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "tr_malloc_kb",
+			"name": "tracing_malloc_bytes",
+			"help": "jcmd VM.native_memory section Tracing metric Malloc Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "tr_malloc_total",
+			"name": "tracing_malloc_total",
+			"help": "jcmd VM.native_memory section Tracing metric Malloc Total",
+			"convert": ""
+		},
+
+		{
+			"regex_group": "sv_resv_kb",
+			"name": "serviceability_reserved_bytes",
+			"help": "jcmd VM.native_memory section Serviceability metric Reserved Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "sv_comm_kb",
+			"name": "serviceability_committed_bytes",
+			"help": "jcmd VM.native_memory section Serviceability metric Committed Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "sv_malloc_kb",
+			"name": "serviceability_malloc_bytes",
+			"help": "jcmd VM.native_memory section Serviceability metric Malloc Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "sv_malloc_total",
+			"name": "serviceability_malloc_total",
+			"help": "jcmd VM.native_memory section Serviceability metric Malloc Total",
+			"convert": ""
+		},
+
+		{
+			"regex_group": "ms_resv_kb",
+			"name": "metaspace_reserved_bytes",
+			"help": "jcmd VM.native_memory section Metaspace metric Reserved Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "ms_comm_kb",
+			"name": "metaspace_committed_bytes",
+			"help": "jcmd VM.native_memory section Metaspace metric Committed Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "ms_malloc_kb",
+			"name": "metaspace_malloc_bytes",
+			"help": "jcmd VM.native_memory section Metaspace metric Malloc Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "ms_malloc_total",
+			"name": "metaspace_malloc_total",
+			"help": "jcmd VM.native_memory section Metaspace metric Malloc Total",
+			"convert": ""
+		},
+		{
+			"regex_group": "ms_mm_resv_kb",
+			"name": "metaspace_mmap_reserved_bytes",
+			"help": "jcmd VM.native_memory section Metaspace metric Mmap Reserved Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "ms_mm_comm_kb",
+			"name": "metaspace_mmap_committed_bytes",
+			"help": "jcmd VM.native_memory section Metaspace metric Mmap Committed Bytes",
+			"convert": "kb_to_bytes"
+		},
+
+		{
+			"regex_group": "sd_resv_kb",
+			"name": "string_deduplication_reserved_bytes",
+			"help": "jcmd VM.native_memory section String Deduplication metric Reserved Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "sd_comm_kb",
+			"name": "string_deduplication_committed_bytes",
+			"help": "jcmd VM.native_memory section String Deduplication metric Committed Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "sd_malloc_kb",
+			"name": "string_deduplication_malloc_bytes",
+			"help": "jcmd VM.native_memory section String Deduplication metric Malloc Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "sd_malloc_total",
+			"name": "string_deduplication_malloc_total",
+			"help": "jcmd VM.native_memory section String Deduplication metric Malloc Total",
+			"convert": ""
+		},
+
+		{
+			"regex_group": "om_resv_kb",
+			"name": "object_monitors_reserved_bytes",
+			"help": "jcmd VM.native_memory section Object Monitors metric Reserved Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "om_comm_kb",
+			"name": "object_monitors_committed_bytes",
+			"help": "jcmd VM.native_memory section Object Monitors metric Committed Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "om_malloc_kb",
+			"name": "object_monitors_malloc_bytes",
+			"help": "jcmd VM.native_memory section Object Monitors metric Malloc Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "om_malloc_total",
+			"name": "object_monitors_malloc_total",
+			"help": "jcmd VM.native_memory section Object Monitors metric Malloc Total",
+			"convert": ""
+		},
+
+		{
+			"regex_group": "un_resv_kb",
+			"name": "unknown_reserved_bytes",
+			"help": "jcmd VM.native_memory section Unknown metric Reserved Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "un_comm_kb",
+			"name": "unknown_committed_bytes",
+			"help": "jcmd VM.native_memory section Unknown metric Committed Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "un_mm_resv_kb",
+			"name": "unknown_mmap_reserved_bytes",
+			"help": "jcmd VM.native_memory section Unknown metric Mmap Reserved Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "un_mm_comm_kb",
+			"name": "unknown_mmap_committed_bytes",
+			"help": "jcmd VM.native_memory section Unknown metric Mmap Committed Bytes",
+			"convert": "kb_to_bytes"
+		},
+
+		{
+			"regex_group": "to_malloc_peak_kb",
+			"name": "total_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Total metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "hp_mm_peak_kb",
+			"name": "java_heap_mmap_peak_bytes",
+			"help": "jcmd VM.native_memory section Java Heap metric Mmap Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "cl_malloc_peak_kb",
+			"name": "class_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Class metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "cl_mm_peak_kb",
+			"name": "class_mmap_peak_bytes",
+			"help": "jcmd VM.native_memory section Class metric Mmap Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "th_malloc_peak_kb",
+			"name": "thread_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Thread metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "th_arena_peak_kb",
+			"name": "thread_arena_peak_bytes",
+			"help": "jcmd VM.native_memory section Thread metric Arena Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "th_s_peak_kb",
+			"name": "thread_stack_peak_bytes",
+			"help": "jcmd VM.native_memory section Thread metric Stack Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "co_malloc_peak_kb",
+			"name": "code_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Code metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "co_mm_peak_kb",
+			"name": "code_mmap_peak_bytes",
+			"help": "jcmd VM.native_memory section Code metric Mmap Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "gc_malloc_peak_kb",
+			"name": "gc_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section GC metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "gc_mm_peak_kb",
+			"name": "gc_mmap_peak_bytes",
+			"help": "jcmd VM.native_memory section GC metric Mmap Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "cp_malloc_peak_kb",
+			"name": "compiler_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Compiler metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "cp_arena_peak_kb",
+			"name": "compiler_arena_peak_bytes",
+			"help": "jcmd VM.native_memory section Compiler metric Arena Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "in_malloc_peak_kb",
+			"name": "internal_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Internal metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "in_mm_peak_kb",
+			"name": "internal_mmap_peak_bytes",
+			"help": "jcmd VM.native_memory section Internal metric Mmap Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "sy_malloc_peak_kb",
+			"name": "symbol_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Symbol metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "sy_arena_peak_kb",
+			"name": "symbol_arena_peak_bytes",
+			"help": "jcmd VM.native_memory section Symbol metric Arena Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "nm_malloc_peak_kb",
+			"name": "native_memory_tracking_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Native Memory Tracking metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "sc_mm_peak_kb",
+			"name": "shared_class_space_mmap_peak_bytes",
+			"help": "jcmd VM.native_memory section Shared Class Space metric Mmap Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "ac_malloc_peak_kb",
+			"name": "arena_chunk_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Arena Chunk metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "mo_malloc_peak_kb",
+			"name": "module_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Module metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "sp_mm_peak_kb",
+			"name": "safepoint_mmap_peak_bytes",
+			"help": "jcmd VM.native_memory section Safepoint metric Mmap Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "sn_malloc_peak_kb",
+			"name": "synchronization_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Synchronization metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "ot_malloc_peak_kb",
+			"name": "other_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Other metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "gcs_malloc_peak_kb",
+			"name": "gccardset_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section GCCardSet metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "tr_malloc_peak_kb",
+			"name": "tracing_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Tracing metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "sv_malloc_peak_kb",
+			"name": "serviceability_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Serviceability metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "ms_malloc_peak_kb",
+			"name": "metaspace_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Metaspace metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "ms_mm_peak_kb",
+			"name": "metaspace_mmap_peak_bytes",
+			"help": "jcmd VM.native_memory section Metaspace metric Mmap Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "sd_malloc_peak_kb",
+			"name": "string_deduplication_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section String Deduplication metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		},
+		{
+			"regex_group": "om_malloc_peak_kb",
+			"name": "object_monitors_malloc_peak_bytes",
+			"help": "jcmd VM.native_memory section Object Monitors metric Malloc Peak Bytes",
+			"convert": "kb_to_bytes"
+		}
+	],
+	"heap_info": [
+		{
+			"regex_group": "capacity",
+			"name": "capacity_bytes",
+			"help": "jcmd GC.heap_info capacity of a space in bytes",
+			"convert": "bytes",
+			"labels": ["space"]
+		},
+		{
+			"regex_group": "used",
+			"name": "used_bytes",
+			"help": "jcmd GC.heap_info used bytes of a space, estimated from the percentage for eden, from and to spaces",
+			"convert": "bytes",
+			"labels": ["space"]
+		},
+		{
+			"regex_group": "committed",
+			"name": "committed_bytes",
+			"help": "jcmd GC.heap_info committed bytes of a space",
+			"convert": "bytes",
+			"labels": ["space"]
+		},
+		{
+			"regex_group": "reserved",
+			"name": "reserved_bytes",
+			"help": "jcmd GC.heap_info reserved bytes of a space",
+			"convert": "bytes",
+			"labels": ["space"]
+		},
+		{
+			"regex_group": "max",
+			"name": "max_bytes",
+			"help": "jcmd GC.heap_info maximum capacity of a space in bytes",
+			"convert": "bytes",
+			"labels": ["space"]
+		},
+		{
+			"regex_group": "regions",
+			"name": "regions",
+			"help": "jcmd GC.heap_info regions of a space",
+			"labels": ["space"]
+		},
+		{
+			"regex_group": "region_size",
+			"name": "region_size_bytes",
+			"help": "jcmd GC.heap_info region size in bytes",
+			"convert": "bytes"
+		},
+		{
+			"regex_group": "collector",
+			"name": "collector_info",
+			"help": "jcmd GC.heap_info garbage collector in use",
+			"labels": ["collector"]
+		}
+	],
+	"thread_print": [
+		{
+			"regex_group": "threads",
+			"name": "threads",
+			"help": "jcmd Thread.print Java threads by java.lang.Thread.State",
+			"labels": ["state"]
+		},
+		{
+			"regex_group": "daemon_threads",
+			"name": "daemon_threads",
+			"help": "jcmd Thread.print Java daemon threads"
+		},
+		{
+			"regex_group": "non_daemon_threads",
+			"name": "non_daemon_threads",
+			"help": "jcmd Thread.print Java non-daemon threads"
+		},
+		{
+			"regex_group": "vm_threads",
+			"name": "vm_threads",
+			"help": "jcmd Thread.print JVM internal threads like GC and compiler threads"
+		},
+		{
+			"regex_group": "pool_threads",
+			"name": "pool_threads",
+			"help": "jcmd Thread.print threads by name with its thread index and executor ordinal replaced by *",
+			"labels": ["pool"]
+		},
+		{
+			"regex_group": "blocked_threads",
+			"name": "blocked_threads",
+			"help": "jcmd Thread.print threads waiting to lock a monitor by the pool of the thread owning it",
+			"labels": ["owner"]
+		},
+		{
+			"regex_group": "pool_cpu",
+			"name": "pool_cpu_seconds_total",
+			"help": "jcmd Thread.print CPU time of the threads of a pool, including threads which ended, since JDK 11",
+			"type": "counter",
+			"labels": ["pool"]
+		},
+		{
+			"regex_group": "deadlocks",
+			"name": "deadlocks_detected",
+			"help": "jcmd Thread.print Java-level deadlocks found"
+		}
+	],
+	"class_histogram": [
+		{
+			"regex_group": "instances",
+			"name": "instances",
+			"help": "jcmd GC.class_histogram instances of the top classes by bytes",
+			"labels": ["class"]
+		},
+		{
+			"regex_group": "bytes",
+			"name": "bytes",
+			"help": "jcmd GC.class_histogram bytes of the top classes by bytes",
+			"labels": ["class"]
+		},
+		{
+			"regex_group": "package_instances",
+			"name": "package_instances",
+			"help": "jcmd GC.class_histogram instances of the top packages by bytes",
+			"labels": ["package"]
+		},
+		{
+			"regex_group": "package_bytes",
+			"name": "package_bytes",
+			"help": "jcmd GC.class_histogram bytes of the top packages by bytes",
+			"labels": ["package"]
+		},
+		{
+			"regex_group": "total_instances",
+			"name": "total_instances",
+			"help": "jcmd GC.class_histogram instances of all classes"
+		},
+		{
+			"regex_group": "total_bytes",
+			"name": "total_bytes",
+			"help": "jcmd GC.class_histogram bytes of all classes"
+		}
+	],
+	"vm_flags": [
+		{
+			"regex_group": "max_heap_size",
+			"name": "max_heap_size_bytes",
+			"help": "jcmd VM.flags MaxHeapSize in bytes",
+			"convert": "bytes"
+		},
+		{
+			"regex_group": "max_metaspace_size",
+			"name": "max_metaspace_size_bytes",
+			"help": "jcmd VM.flags MaxMetaspaceSize in bytes",
+			"convert": "bytes"
+		},
+		{
+			"regex_group": "max_direct_memory_size",
+			"name": "max_direct_memory_size_bytes",
+			"help": "jcmd VM.flags MaxDirectMemorySize in bytes, 0 is the default of MaxHeapSize",
+			"convert": "bytes"
+		},
+		{
+			"regex_group": "reserved_code_cache_size",
+			"name": "reserved_code_cache_size_bytes",
+			"help": "jcmd VM.flags ReservedCodeCacheSize in bytes",
+			"convert": "bytes"
+		},
+		{
+			"regex_group": "info",
+			"name": "info",
+			"help": "jcmd VM.flags garbage collector in use and NativeMemoryTracking level, always 1",
+			"labels": ["gc", "native_memory_tracking"]
+		},
+		{
+			"regex_group": "manageable_flag_changes",
+			"name": "manageable_flag_changes_total",
+			"help": "jcmd VM.flags -all changes of a manageable flag between collections",
+			"type": "counter",
+			"labels": ["flag"]
 		}
 	]
 }`
 
-const DEFAULT_REGEX_PATTERN = `(?ms)` +
-
-	// Total section (to_)
-	`^Total: reserved=(?P<to_resv_kb>\d+)KB, committed=(?P<to_comm_kb>\d+)KB.+` +
-
-	// Java Heap section (hp_)
-	`-\s+Java Heap \(reserved=(?P<hp_resv_kb>\d+)KB, committed=(?P<hp_comm_kb>\d+)KB\).+` +
-	`\(mmap: reserved=(?P<hp_mm_resv_kb>\d+)KB, committed=(?P<hp_mm_comm_kb>\d+)KB\).+` +
-
-	// Class section (cl_)
-	`-\s+Class \(reserved=(?P<cl_resv_kb>\d+)KB, committed=(?P<cl_comm_kb>\d+)KB\).+` +
-	`\(classes #(?P<cl_classes_total>\d+)\).+` +
-	`\(\s+instance classes #(?P<cl_ic_total>\d+), array classes #(?P<cl_ac_total>\d+)\).+` +
-	`\(malloc=(?P<cl_malloc_kb>\d+)KB #(?P<cl_malloc_total>\d+)\).+` +
-	`\(mmap: reserved=(?P<cl_mm_resv_kb>\d+)KB, committed=(?P<cl_mm_comm_kb>\d+)KB\).+` +
-
-	// Class Metadata subsection (cl_me_)
-	`\(\s+reserved=(?P<cl_me_resv_kb>\d+)KB, committed=(?P<cl_me_comm_kb>\d+)KB\).+` +
-	`\(\s+used=(?P<cl_me_used_kb>\d+)KB\).+` +
-	`\(\s+free=(?P<cl_me_free_kb>\d+)KB\).+` +
-	`\(\s+waste=(?P<cl_me_waste_kb>\d+)KB =.+%\).+` +
-
-	// Class Class Space subsection (cl_cs_)
-	`\(\s+reserved=(?P<cl_cs_resv_kb>\d+)KB, committed=(?P<cl_cs_comm_kb>\d+)KB\).+` +
-	`\(\s+used=(?P<cl_cs_used_kb>\d+)KB\).+` +
-	`\(\s+free=(?P<cl_cs_free_kb>\d+)KB\).+` +
-	`\(\s+waste=(?P<cl_cs_waste_kb>\d+)KB =.+%\).+` +
-
-	// Thread section (th_)
-	`-\s+Thread\s+\(reserved=(?P<th_resv_kb>\d+)KB, committed=(?P<th_comm_kb>\d+)KB\).+` +
-	`\(thread #(?P<th_total>\d+)\).+` +
-	`\(stack: reserved=(?P<th_s_resv_kb>\d+)KB, committed=(?P<th_s_comm_kb>\d+)KB\).+` +
-	`\(malloc=(?P<th_malloc_kb>\d+)KB #(?P<th_malloc_total>\d+)\).+` +
-	`\(arena=(?P<th_arena_kb>\d+)KB #(?P<th_arena_total>\d+)\).+` +
-
-	// Code section (co_)
-	`-\s+Code \(reserved=(?P<co_resv_kb>\d+)KB, committed=(?P<co_comm_kb>\d+)KB\).+` +
-	`\s+\(malloc=(?P<co_malloc_kb>\d+)KB #(?P<co_malloc_total>\d+)\).+` +
-	`\s+\(mmap: reserved=(?P<co_mm_resv_kb>\d+)KB, committed=(?P<co_mm_comm_kb>\d+)KB\).+` +
-
-	// GC section (gc_)
-	`-\s+GC \(reserved=(?P<gc_resv_kb>\d+)KB, committed=(?P<gc_comm_kb>\d+)KB\).+` +
-	`\s+\(malloc=(?P<gc_malloc_kb>\d+)KB #(?P<gc_malloc_total>\d+)\).+` +
-	`\s+\(mmap: reserved=(?P<gc_mm_resv_kb>\d+)KB, committed=(?P<gc_mm_comm_kb>\d+)KB\).+` +
-
-	// Compiler section (cp_)
-	`-\s+Compiler \(reserved=(?P<cp_resv_kb>\d+)KB, committed=(?P<cp_comm_kb>\d+)KB\).+` +
-	`\s+\(malloc=(?P<cp_malloc_kb>\d+)KB #(?P<cp_malloc_total>\d+)\).+` +
-	`\s+\(arena=(?P<cp_arena_kb>\d+)KB #(?P<cp_arena_total>\d+)\).+` +
-
-	// Internal section (in_)
-	`-\s+Internal \(reserved=(?P<in_resv_kb>\d+)KB, committed=(?P<in_comm_kb>\d+)KB\).+` +
-	`\s+\(malloc=(?P<in_malloc_kb>\d+)KB #(?P<in_malloc_total>\d+)\).+` +
-	`\s+\(mmap: reserved=(?P<in_mm_resv_kb>\d+)KB, committed=(?P<in_mm_comm_kb>\d+)KB\).+` +
-
-	// Symbol section (sy_)
-	`-\s+Symbol \(reserved=(?P<sy_resv_kb>\d+)KB, committed=(?P<sy_comm_kb>\d+)KB\).+` +
-	`\s+\(malloc=(?P<sy_malloc_kb>\d+)KB #(?P<sy_malloc_total>\d+)\).+` +
-	`\s+\(arena=(?P<sy_arena_kb>\d+)KB #(?P<sy_arena_total>\d+)\).+` +
-
-	// Native Memory Tracking section (nm_)
-	`-\s+Native Memory Tracking \(reserved=(?P<nm_resv_kb>\d+)KB, committed=(?P<nm_comm_kb>\d+)KB\).+` +
-	`\s+\(malloc=(?P<nm_malloc_kb>\d+)KB #(?P<nm_malloc_total>\d+)\).+` +
-	`\s+\(tracking overhead=(?P<nm_overhead_kb>\d+)KB\).+` +
-
-	// Shared Class Space section (sc_)
-	`-\s+Shared class space \(reserved=(?P<sc_resv_kb>\d+)KB, committed=(?P<sc_comm_kb>\d+)KB\).+` +
-	`\s+\(mmap: reserved=(?P<sc_mm_resv_kb>\d+)KB, committed=(?P<sc_mm_comm_kb>\d+)KB\).+` +
-
-	// Arena Chunk section (ac_)
-	`-\s+Arena Chunk \(reserved=(?P<ac_resv_kb>\d+)KB, committed=(?P<ac_comm_kb>\d+)KB\).+` +
-	`\s+\(malloc=(?P<ac_malloc_kb>\d+)KB\).+` +
-
-	// Logging section (lo_)
-	`-\s+Logging \(reserved=(?P<lo_resv_kb>\d+)KB, committed=(?P<lo_comm_kb>\d+)KB\).+` +
-	`\s+\(malloc=(?P<lo_malloc_kb>\d+)KB #(?P<lo_malloc_total>\d+)\).+` +
-
-	// Arguments section (ar_)
-	`-\s+Arguments \(reserved=(?P<ar_resv_kb>\d+)KB, committed=(?P<ar_comm_kb>\d+)KB\).+` +
-	`\s+\(malloc=(?P<ar_malloc_kb>\d+)KB #(?P<ar_malloc_total>\d+)\).+` +
-
-	// Module section (mo_)
-	`-\s+Module \(reserved=(?P<mo_resv_kb>\d+)KB, committed=(?P<mo_comm_kb>\d+)KB\).+` +
-	`\s+\(malloc=(?P<mo_malloc_kb>\d+)KB #(?P<mo_malloc_total>\d+)\).+` +
-
-	// Safepoint section (sp_)
-	`-\s+Safepoint \(reserved=(?P<sp_resv_kb>\d+)KB, committed=(?P<sp_comm_kb>\d+)KB\).+` +
-	`\s+\(mmap: reserved=(?P<sp_mm_resv_kb>\d+)KB, committed=(?P<sp_mm_comm_kb>\d+)KB\).+` +
-
-	// Synchronization section (sn_)
-	`-\s+Synchronization \(reserved=(?P<sn_resv_kb>\d+)KB, committed=(?P<sn_comm_kb>\d+)KB\).+` +
-	`\s+\(malloc=(?P<sn_malloc_kb>\d+)KB #(?P<sn_malloc_total>\d+)\)`
-
-func ParseMetricDescJson(data []byte) *[]MetricDescAttr {
-
-	type MetricsAll struct {
-		Attr []MetricDescAttr `json:"native_memory"`
+// ParseMetricSets parses metric sets keyed by their name from JSON like
+// DEFAULT_METRICS_JSON.
+func ParseMetricSets(data []byte) (map[string][]MetricDescAttr, error) {
+
+	var sets map[string][]MetricDescAttr
+
+	if err := json.Unmarshal(data, &sets); err != nil {
+		return nil, err
 	}
 
-	var metrics MetricsAll
+	return sets, nil
+}
+
+// NewMetricVecs creates and registers the vectors of a metric set, with
+// the labels of the set followed by the labels of each metric. Converters
+// are looked up in defined first. On error nothing stays registered.
+func NewMetricVecs(reg prometheus.Registerer, metricsSubsystem string, m *[]MetricDescAttr, labelNames []string, defined map[string]ConvertFunction) (*metricVecs, error) {
 
-	if err := json.Unmarshal(data, &metrics); err != nil {
-		log.Fatalf("Couldn't parse JSON %v\n", err)
+	mv := make(metricVecs, len(*m))
+
+	metricsNamespace := "jcmd"
+
+	for _, attr := range *m {
+		names := append(append([]string{}, labelNames...), attr.Labels...)
+
+		valueType, ok := metricValueTypes[attr.Type]
+		if !ok {
+			mv.Unregister(reg)
+			return nil, fmt.Errorf("metric %s has unknown type '%s'", attr.Name, attr.Type)
+		}
+
+		convertFn, err := LookupConverter(attr.Convert, defined)
+		if err != nil {
+			mv.Unregister(reg)
+			return nil, fmt.Errorf("metric %s - %v", attr.Name, err)
+		}
+
+		vec := newValueVec(prometheus.BuildFQName(metricsNamespace, metricsSubsystem, attr.Name), attr.Help, valueType, names)
+
+		if err := reg.Register(vec); err != nil {
+			mv.Unregister(reg)
+			return nil, fmt.Errorf("can not register metric %s_%s_%s - %v", metricsNamespace, metricsSubsystem, attr.Name, err)
+		}
+
+		mv[attr.ReGroup] = MetricVec{
+			Name:      attr.Name,
+			Vec:       vec,
+			ConvertFn: convertFn,
+		}
 	}
 
-	// TODO validate "convert" field
+	return &mv, nil
+}
+
+func (mv *metricVecs) Unregister(reg prometheus.Registerer) {
 
-	return &metrics.Attr
+	for _, v := range *mv {
+		reg.Unregister(v.Vec)
+	}
 }
 
-func GetConvertFunc(name string) (foo ConvertFunction) {
+// Register registers the vectors again after Unregister.
+func (mv *metricVecs) Register(reg prometheus.Registerer) error {
 
-	foo = nil
+	for _, v := range *mv {
+		if err := reg.Register(v.Vec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-	switch name {
-	case "":
-		foo = ConvertFnBasic
-	case "kb_to_bytes":
-		foo = ConvertFnKbToBytes
-	default:
-		log.Fatal("Unknown convert function name", name)
+// NewMetricsMap binds the vectors of a metric set to the labels of one target.
+// Label names of the set missing in labels are set to "".
+func (mv *metricVecs) NewMetricsMap(labels prometheus.Labels) *metricsMap {
+
+	mm := make(metricsMap, len(*mv))
+
+	for group_name, v := range *mv {
+		mm[group_name] = &Metric{
+			Name:      v.Name,
+			Vec:       v.Vec,
+			ConvertFn: v.ConvertFn,
+		}
 	}
 
-	return
+	mm.SetLabels(labels)
+
+	return &mm
 }
 
-func NewMetricsMap(m *[]MetricDescAttr) *metricsMap {
+// SetLabels moves the map to a new label set. Existing series are deleted,
+// new ones appear with the next Set.
+func (m *metricsMap) SetLabels(labels prometheus.Labels) {
 
-	mm := make(metricsMap, len(*m))
+	m.Delete()
 
-	metricsNamespace := "jcmd"
-	metricsSubsystem := "native_memory"
+	for _, metric := range *m {
+		metric.Labels = labels
+	}
+}
 
-	for _, attr := range *m {
-		gauge := promauto.NewGauge(prometheus.GaugeOpts{
-			Namespace: metricsNamespace,
-			Subsystem: metricsSubsystem,
-			Name:      attr.Name,
-			Help:      attr.Help,
-		})
+// Delete removes the series of the map from their vectors.
+func (m *metricsMap) Delete() {
+
+	for _, metric := range *m {
+		if metric.set {
+			metric.Vec.Delete(metric.Labels)
+			metric.set = false
+		}
+		for key, labels := range metric.series {
+			metric.Vec.Delete(labels)
+			delete(metric.series, key)
+		}
+	}
+}
+
+// Update sets the metrics of the samples and fails only when none of them
+// belongs to the map. Series of samples with labels which are missing from
+// this update are deleted, the others keep their last value.
+func (m *metricsMap) Update(samples []Sample) error {
+
+	byName := make(map[string]*Metric, len(*m))
+	for _, metric := range *m {
+		byName[metric.Name] = metric
+	}
+
+	updated := 0
+	seen := make(map[*Metric]map[string]bool)
+
+	for _, sample := range samples {
+
+		metric, ok := (*m)[sample.Name]
+		if !ok {
+			if metric, ok = byName[sample.Name]; !ok {
+				continue
+			}
+		}
+
+		v, err := metric.ConvertFn(sample.Value)
+		if err != nil {
+			log.Printf("ERROR can not convert value '%s' of metric '%s' - %v\n", sample.Value, metric.Name, err)
+			continue
+		}
 
-		gauge.Set(0.0)
+		if len(sample.Labels) == 0 {
+			if err := metric.Set(v); err != nil {
+				log.Printf("ERROR metric '%s' - %v\n", metric.Name, err)
+				continue
+			}
+			updated++
+			continue
+		}
+
+		key, err := metric.SetWith(sample.Labels, v)
+		if err != nil {
+			log.Printf("ERROR metric '%s' - %v\n", metric.Name, err)
+			continue
+		}
 
-		mm[attr.ReGroup] = Metric{
-			Gauge:     &gauge,
-			ConvertFn: GetConvertFunc(attr.Convert),
+		if seen[metric] == nil {
+			seen[metric] = make(map[string]bool)
 		}
+		seen[metric][key] = true
+		updated++
 	}
 
-	return &mm
+	for _, metric := range *m {
+		for key, labels := range metric.series {
+			if !seen[metric][key] {
+				metric.Vec.Delete(labels)
+				delete(metric.series, key)
+			}
+		}
+	}
+
+	if updated == 0 {
+		return fmt.Errorf("no known values found in output")
+	}
+
+	return nil
 }
 
-func ConvertFnBasic(v string) (float64, error) {
+// Set updates the series, creating it on the first call so that no value is
+// exported before the first successful collection.
+func (metric *Metric) Set(v float64) error {
+
+	if err := metric.Vec.Set(metric.Labels, v); err != nil {
+		return err
+	}
+	metric.set = true
 
-	return strconv.ParseFloat(v, 64)
+	return nil
 }
 
-func ConvertFnKbToBytes(v string) (float64, error) {
+// SetWith updates the series with the extra labels of a sample and returns
+// its key in the series of the metric.
+func (metric *Metric) SetWith(extra map[string]string, v float64) (string, error) {
+
+	labels := make(prometheus.Labels, len(metric.Labels)+len(extra))
+	for name, value := range metric.Labels {
+		labels[name] = value
+	}
+	for name, value := range extra {
+		labels[name] = value
+	}
+
+	if err := metric.Vec.Set(labels, v); err != nil {
+		return "", err
+	}
 
-	var (
-		fvalue float64
-		err    error
-	)
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	if fvalue, err = strconv.ParseFloat(v, 64); err == nil {
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + "=" + extra[name] + "\x00")
+	}
+	key := b.String()
 
-		fvalue *= 1024
+	if metric.series == nil {
+		metric.series = make(map[string]prometheus.Labels)
 	}
+	metric.series[key] = labels
 
-	return fvalue, err
+	return key, nil
 }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+)
+
+var metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// check cross-checks the metric sets against each other and against the
+// named groups of the parsers of the targets using them. Parsers which
+// name their samples while parsing, like the one of VM.native_memory, are
+// not checked. Errors make the config invalid, warnings are only logged.
+func (c *Config) check() (errs []string, warnings []string) {
+
+	fail := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, a...))
+	}
+	warn := func(format string, a ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, a...))
+	}
+
+	setNames := make([]string, 0, len(c.MetricSets))
+	for name := range c.MetricSets {
+		setNames = append(setNames, name)
+	}
+	sort.Strings(setNames)
+
+	fqNames := make(map[string]string)
+
+	for _, name := range setNames {
+		groups := make(map[string]bool)
+
+		for i, attr := range c.MetricSets[name] {
+			fqName := fmt.Sprintf("jcmd_%s_%s", name, attr.Name)
+
+			if !metricNameRE.MatchString(fqName) {
+				fail("metric set '%s' item %d: invalid metric name '%s'", name, i, fqName)
+			} else if other, ok := fqNames[fqName]; ok {
+				fail("metric set '%s' item %d: metric '%s' is already defined by metric set '%s'", name, i, fqName, other)
+			}
+			fqNames[fqName] = name
+
+			if groups[attr.ReGroup] {
+				fail("metric set '%s' item %d: duplicate regex_group '%s'", name, i, attr.ReGroup)
+			}
+			groups[attr.ReGroup] = true
+		}
+	}
+
+	// each parser and metric set pair once, reported for its first target,
+	// discovered targets use the template
+	type use struct {
+		subsystem string
+		set       string
+	}
+	uses := make(map[use]string)
+
+	// the static labels of a target are labels of all metrics of its set,
+	// the same name as a sample label would be a duplicate label
+	checkTarget := func(where string, t *TargetConfig) {
+		u := use{t.SubSystem, t.Metrics}
+		if _, ok := uses[u]; !ok {
+			uses[u] = where
+		}
+
+		reported := make(map[string]bool)
+		for _, attr := range c.MetricSets[t.Metrics] {
+			for _, label := range attr.Labels {
+				if _, ok := t.Labels[label]; ok && !reported[label] {
+					fail("%s: static label '%s' is also a label of metric '%s' of metric set '%s'", where, label, attr.Name, t.Metrics)
+					reported[label] = true
+				}
+			}
+		}
+
+		if (&JcmdTask{SubSystem: t.SubSystem, ExtraArgs: t.ExtraArgs}).IsNmtDetail() {
+			for _, label := range nmtDetailLabels {
+				if _, ok := t.Labels[label]; ok {
+					fail("%s: static label '%s' is also a label of the NMT detail callsite metrics", where, label)
+				}
+			}
+		}
+	}
+
+	for i := range c.Targets {
+		checkTarget("target '"+c.Targets[i].Name+"'", &c.Targets[i])
+	}
+	if c.Discovery.Enabled {
+		t := c.Discovery.targetFor(&JvmInfo{Pid: 1, MainClass: "Main"})
+		checkTarget("discovery target", &t)
+	}
+
+	keys := make([]use, 0, len(uses))
+	for u := range uses {
+		keys = append(keys, u)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].subsystem != keys[j].subsystem {
+			return keys[i].subsystem < keys[j].subsystem
+		}
+		return keys[i].set < keys[j].set
+	})
+
+	for _, u := range keys {
+		attrs, ok := c.MetricSets[u.set]
+		if !ok {
+			continue
+		}
+
+		parser, ok := NewParser(&JcmdTask{SubSystem: u.subsystem}, c.Parsers).(GroupsParser)
+		if !ok {
+			continue
+		}
+
+		groups := make(map[string]bool)
+		for _, group := range parser.Groups() {
+			groups[group] = true
+		}
+
+		exported := make(map[string]bool)
+		for _, attr := range attrs {
+			group := attr.ReGroup
+			if !groups[group] {
+				group = attr.Name
+			}
+			if !groups[group] {
+				fail("%s: metric set '%s' regex_group '%s' is no named group of the %s parser", uses[u], u.set, attr.ReGroup, u.subsystem)
+				continue
+			}
+			exported[group] = true
+
+			if labels := parser.Labels(group); !sameLabels(attr.Labels, labels) {
+				fail("%s: metric set '%s' regex_group '%s' has labels %v, the %s parser gives it %v", uses[u], u.set, attr.ReGroup, attr.Labels, u.subsystem, labels)
+			}
+		}
+
+		for _, group := range parser.Groups() {
+			if !exported[group] {
+				warn("%s: group '%s' of the %s parser is not exported by metric set '%s'", uses[u], group, u.subsystem, u.set)
+				exported[group] = true
+			}
+		}
+	}
+
+	return errs, warnings
+}
+
+// sameLabels tells whether a and b have the same label names in any order.
+func sameLabels(a []string, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// runValidate loads the config like the exporter would, prints the
+// problems found and returns the exit code: 1 on any error or warning.
+func runValidate() int {
+
+	var config *Config
+	var err error
+
+	if *optConfigFile == "" {
+		config, err = DefaultConfig(*optMainClass)
+	} else {
+		config, err = LoadConfig(*optConfigFile)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR %v\n", err)
+		return 1
+	}
+
+	for _, warning := range config.warnings {
+		fmt.Fprintf(os.Stderr, "WARNING %s\n", warning)
+	}
+
+	if len(config.warnings) > 0 {
+		return 1
+	}
+
+	fmt.Println("OK")
+
+	return 0
+}
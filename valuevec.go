@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	METRIC_TYPE_GAUGE   = "gauge"
+	METRIC_TYPE_COUNTER = "counter"
+	METRIC_TYPE_UNTYPED = "untyped"
+)
+
+var metricValueTypes = map[string]prometheus.ValueType{
+	"":                  prometheus.GaugeValue,
+	METRIC_TYPE_GAUGE:   prometheus.GaugeValue,
+	METRIC_TYPE_COUNTER: prometheus.CounterValue,
+	METRIC_TYPE_UNTYPED: prometheus.UntypedValue,
+}
+
+// valueVec is a vector of values read from jcmd output, exported with the
+// type of their definition. Unlike prometheus.CounterVec its counters are
+// set, since jcmd prints totals.
+type valueVec struct {
+	desc       *prometheus.Desc
+	valueType  prometheus.ValueType
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]valueSeries
+}
+
+type valueSeries struct {
+	labelValues []string
+	value       float64
+}
+
+func newValueVec(name string, help string, valueType prometheus.ValueType, labelNames []string) *valueVec {
+
+	return &valueVec{
+		desc:       prometheus.NewDesc(name, help, labelNames, nil),
+		valueType:  valueType,
+		labelNames: labelNames,
+		values:     make(map[string]valueSeries),
+	}
+}
+
+func (v *valueVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.desc
+}
+
+func (v *valueVec) Collect(ch chan<- prometheus.Metric) {
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, s := range v.values {
+		ch <- prometheus.MustNewConstMetric(v.desc, v.valueType, s.value, s.labelValues...)
+	}
+}
+
+// Set sets the series of labels, which must hold exactly the label names of
+// the vector.
+func (v *valueVec) Set(labels prometheus.Labels, value float64) error {
+
+	values, err := v.labelValues(labels)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.values[strings.Join(values, "\x00")] = valueSeries{labelValues: values, value: value}
+
+	return nil
+}
+
+// Delete removes the series of labels and tells whether it existed.
+func (v *valueVec) Delete(labels prometheus.Labels) bool {
+
+	values, err := v.labelValues(labels)
+	if err != nil {
+		return false
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key := strings.Join(values, "\x00")
+	_, ok := v.values[key]
+	delete(v.values, key)
+
+	return ok
+}
+
+func (v *valueVec) labelValues(labels prometheus.Labels) ([]string, error) {
+
+	if len(labels) != len(v.labelNames) {
+		return nil, fmt.Errorf("got %d labels, want %v", len(labels), v.labelNames)
+	}
+
+	values := make([]string, len(v.labelNames))
+
+	for i, name := range v.labelNames {
+		value, ok := labels[name]
+		if !ok {
+			return nil, fmt.Errorf("label %s missing, want %v", name, v.labelNames)
+		}
+		values[i] = value
+	}
+
+	return values, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	VM_FLAGS_COMMAND        = "VM.flags"
+	VM_COMMAND_LINE_COMMAND = "VM.command_line"
+	VM_FLAGS_METRICS_SET    = "vm_flags"
+)
+
+var (
+	// a row of "VM.flags -all": type, name, value and kinds like {product}
+	vmFlagRowRE   = regexp.MustCompile(`^\s*\w+\s+(\w+)\s+:?=\s+([^\s{]\S*)?\s*(\{.*\})\s*$`)
+	vmFlagKindRE  = regexp.MustCompile(`\{([^}]*)\}`)
+	vmFlagArgRE   = regexp.MustCompile(`^-XX:(?:([+-])(\w+)|(\w+)=(.*))$`)
+	vmFlagSizeRE  = regexp.MustCompile(`^(\d+)([kKmMgGtT])$`)
+	vmJvmArgsLine = "jvm_args:"
+	vmFlagsLine   = "-XX:"
+)
+
+// flags exported as gauges by their sample name, sizes in bytes
+var vmFlagSizes = map[string]string{
+	"MaxHeapSize":           "max_heap_size",
+	"MaxMetaspaceSize":      "max_metaspace_size",
+	"MaxDirectMemorySize":   "max_direct_memory_size",
+	"ReservedCodeCacheSize": "reserved_code_cache_size",
+}
+
+// collector of the Use*GC flags, named like in GC.heap_info
+var vmFlagCollectors = map[string]string{
+	"UseG1GC":            "G1",
+	"UseParallelGC":      "Parallel",
+	"UseSerialGC":        "Serial",
+	"UseConcMarkSweepGC": "CMS",
+	"UseZGC":             "Z",
+	"UseShenandoahGC":    "Shenandoah",
+	"UseEpsilonGC":       "Epsilon",
+}
+
+func init() {
+	RegisterParser(VM_FLAGS_COMMAND, newVmFlagsParser)
+	RegisterParser(VM_COMMAND_LINE_COMMAND, newVmFlagsParser)
+}
+
+// vmFlagsParser reads the flags of "VM.flags -all", "VM.flags" or the
+// jvm_args of "VM.command_line"; the latter two only show flags which are
+// set. Only "-all" marks the manageable flags, their changes between
+// collections are counted. The arguments of the application, which may look
+// like JVM flags, are never read.
+type vmFlagsParser struct {
+	task *JcmdTask
+
+	mu      sync.Mutex
+	pid     string
+	values  map[string]string
+	changes map[string]int
+}
+
+func newVmFlagsParser(task *JcmdTask) Parser {
+	return &vmFlagsParser{task: task}
+}
+
+func (p *vmFlagsParser) Groups() []string {
+
+	groups := []string{"info", "manageable_flag_changes"}
+	for _, name := range vmFlagSizes {
+		groups = append(groups, name)
+	}
+
+	return groups
+}
+
+func (p *vmFlagsParser) Labels(group string) []string {
+
+	switch group {
+	case "info":
+		return []string{"gc", "native_memory_tracking"}
+	case "manageable_flag_changes":
+		return []string{"flag"}
+	}
+
+	return nil
+}
+
+func (p *vmFlagsParser) Parse(output string) ([]Sample, error) {
+
+	flags := make(map[string]string)
+	manageable := make(map[string]bool)
+
+	for _, line := range strings.Split(output, "\n") {
+
+		line = strings.TrimSpace(line)
+
+		if m := vmFlagRowRE.FindStringSubmatch(line); m != nil {
+			flags[m[1]] = m[2]
+			for _, kind := range vmFlagKindRE.FindAllStringSubmatch(m[3], -1) {
+				if kind[1] == "manageable" {
+					manageable[m[1]] = true
+				}
+			}
+			continue
+		}
+
+		// the flags line of VM.flags, the jvm_args line of VM.command_line
+		if p.task.SubSystem == VM_COMMAND_LINE_COMMAND {
+			if !strings.HasPrefix(line, vmJvmArgsLine) {
+				continue
+			}
+			line = strings.TrimPrefix(line, vmJvmArgsLine)
+		} else if !strings.HasPrefix(line, vmFlagsLine) {
+			continue
+		}
+
+		for _, arg := range strings.Fields(line) {
+			vmFlagArg(flags, arg)
+		}
+	}
+
+	if len(flags) == 0 {
+		return nil, fmt.Errorf("no VM flags in output")
+	}
+
+	samples := make([]Sample, 0, len(vmFlagSizes)+len(manageable)+1)
+
+	for flag, name := range vmFlagSizes {
+		if value, ok := flags[flag]; ok {
+			samples = append(samples, Sample{Name: name, Value: value})
+		}
+	}
+
+	gc := ""
+	for flag, collector := range vmFlagCollectors {
+		if flags[flag] == "true" {
+			gc = collector
+		}
+	}
+
+	samples = append(samples, Sample{Name: "info", Value: "1", Labels: map[string]string{
+		"gc":                     gc,
+		"native_memory_tracking": flags["NativeMemoryTracking"],
+	}})
+
+	for flag, count := range p.countChanges(flags, manageable) {
+		samples = append(samples, Sample{Name: "manageable_flag_changes", Value: strconv.Itoa(count), Labels: map[string]string{"flag": flag}})
+	}
+
+	return samples, nil
+}
+
+// vmFlagArg adds a command line flag like -XX:+UseG1GC, -XX:MaxHeapSize=1g
+// or -Xmx1g. Sizes get a unit ParseSize reads.
+func vmFlagArg(flags map[string]string, arg string) {
+
+	if strings.HasPrefix(arg, "-Xmx") {
+		flags["MaxHeapSize"] = vmFlagSize(arg[len("-Xmx"):])
+		return
+	}
+
+	m := vmFlagArgRE.FindStringSubmatch(arg)
+	if m == nil {
+		return
+	}
+
+	if m[2] != "" {
+		flags[m[2]] = strconv.FormatBool(m[1] == "+")
+		return
+	}
+
+	flags[m[3]] = m[4]
+	if _, ok := vmFlagSizes[m[3]]; ok {
+		flags[m[3]] = vmFlagSize(m[4])
+	}
+}
+
+func vmFlagSize(s string) string {
+
+	if m := vmFlagSizeRE.FindStringSubmatch(s); m != nil {
+		return m[1] + strings.ToUpper(m[2]) + "B"
+	}
+
+	return s
+}
+
+// countChanges counts the changes of the manageable flags since the last
+// collection and returns the counts of all of them. A new JVM starts from
+// scratch.
+func (p *vmFlagsParser) countChanges(flags map[string]string, manageable map[string]bool) map[string]int {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pid := p.task.currentLabels()[LABEL_PID]
+	if p.changes == nil || pid != p.pid {
+		p.pid = pid
+		p.values = make(map[string]string)
+		p.changes = make(map[string]int)
+	}
+
+	counts := make(map[string]int, len(manageable))
+
+	for flag := range manageable {
+		if last, ok := p.values[flag]; ok && last != flags[flag] {
+			p.changes[flag]++
+		}
+		p.values[flag] = flags[flag]
+		counts[flag] = p.changes[flag]
+	}
+
+	return counts
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVmFlagsParser(t *testing.T) {
+
+	tests := []struct {
+		file      string
+		subsystem string
+		cases     []sampleCase
+		// samples the output does not give
+		missing []string
+	}{
+		{"vm_flags/jdk8_all.txt", VM_FLAGS_COMMAND, []sampleCase{
+			{"info", "gc", "Parallel", "1"},
+			{"info", "native_memory_tracking", "off", "1"},
+			{"max_heap_size", "", "", "4175429632"},
+			{"max_direct_memory_size", "", "", "0"},
+			{"reserved_code_cache_size", "", "", "251658240"},
+			{"manageable_flag_changes", "flag", "PrintGC", "0"},
+		}, nil},
+		{"vm_flags/jdk17_all.txt", VM_FLAGS_COMMAND, []sampleCase{
+			{"info", "gc", "G1", "1"},
+			{"info", "native_memory_tracking", "summary", "1"},
+			{"max_heap_size", "", "", "4294967296"},
+			{"max_metaspace_size", "", "", "18446744073709551615"},
+			{"manageable_flag_changes", "flag", "HeapDumpOnOutOfMemoryError", "0"},
+		}, nil},
+		{"vm_flags/jdk17.txt", VM_FLAGS_COMMAND, []sampleCase{
+			{"info", "gc", "G1", "1"},
+			{"max_heap_size", "", "", "4294967296"},
+			{"reserved_code_cache_size", "", "", "251658240"},
+		}, []string{"manageable_flag_changes", "max_metaspace_size"}},
+		// the flag-like arguments of the application are not read
+		{"vm_flags/command_line_jdk17.txt", VM_COMMAND_LINE_COMMAND, []sampleCase{
+			{"info", "gc", "Z", "1"},
+			{"info", "native_memory_tracking", "detail", "1"},
+			{"max_heap_size", "", "", "4GB"},
+			{"max_metaspace_size", "", "", "512MB"},
+			{"max_direct_memory_size", "", "", "1GB"},
+		}, []string{"manageable_flag_changes", "reserved_code_cache_size"}},
+	}
+
+	for _, tt := range tests {
+
+		p := newVmFlagsParser(&JcmdTask{SubSystem: tt.subsystem})
+
+		samples, err := p.Parse(readExample(t, tt.file))
+		if err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+			continue
+		}
+
+		t.Run(tt.file, func(t *testing.T) {
+			checkSamples(t, samples, tt.cases)
+			for _, name := range tt.missing {
+				if value, ok := findSample(samples, name, "", ""); ok {
+					t.Errorf("unexpected %s = %q", name, value)
+				}
+			}
+		})
+	}
+}
+
+func TestVmFlagsChanges(t *testing.T) {
+
+	p := newVmFlagsParser(&JcmdTask{SubSystem: VM_FLAGS_COMMAND})
+	flags := readExample(t, "vm_flags/jdk17_all.txt")
+	changed := strings.Replace(flags, "HeapDumpOnOutOfMemoryError               = true", "HeapDumpOnOutOfMemoryError               = false", 1)
+
+	var samples []Sample
+	for _, output := range []string{flags, changed, changed, flags} {
+		var err error
+		if samples, err = p.Parse(output); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkSamples(t, samples, []sampleCase{
+		{"manageable_flag_changes", "flag", "HeapDumpOnOutOfMemoryError", "2"},
+		{"manageable_flag_changes", "flag", "MinHeapFreeRatio", "0"},
+	})
+}
+
+func TestVmFlagsNoFlags(t *testing.T) {
+
+	p := newVmFlagsParser(&JcmdTask{SubSystem: VM_FLAGS_COMMAND})
+	if _, err := p.Parse("12345:\n"); err == nil {
+		t.Error("no error without flags")
+	}
+}